@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+var validName = regexp.MustCompile(`^[\w.-]+$`)
+
+// artifactPath returns the content-addressed location of an artifact blob outside of the workspace
+func artifactPath(digest string) string {
+	return filepath.Clean(pwd + "/artifacts/sha256/" + digest)
+}
+
+// workspaceFile resolves a file relative to the users workspace without allowing it to escape
+func workspaceFile(name, file string) string {
+	return filepath.Join(pwd+"/upload/"+name, filepath.Clean("/"+file))
+}
+
+// insideDir reports if a path is dir or in it
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// resolveWorkspace resolves a file in the users workspace following symlinks, containers can create them
+// so a file that resolves to outside of the workspace is refused
+func resolveWorkspace(name, file string) (string, error) {
+	root, err := filepath.EvalSymlinks(pwd + "/upload/" + name)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.Clean("/"+file)))
+	if err != nil {
+		return "", err
+	}
+	if !insideDir(root, path) {
+		return "", errors.New(file + " is outside of the workspace")
+	}
+	return path, nil
+}
+
+// openWorkspace opens a regular file in the users workspace for reading, see resolveWorkspace
+func openWorkspace(name, file string) (*os.File, error) {
+	path, err := resolveWorkspace(name, file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The resolved path has no symlinks, so what was opened has to be the regular file that was checked
+	fi, err := f.Stat()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = errors.New(file + " is not a regular file")
+	}
+	if err == nil {
+		if li, lerr := os.Lstat(path); lerr != nil || !os.SameFile(fi, li) {
+			err = errors.New(file + " changed while it was opened")
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// readWorkspace reads a regular file in the users workspace, see resolveWorkspace
+func readWorkspace(name, file string) ([]byte, error) {
+	f, err := openWorkspace(name, file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// storeArtifact copies a workspace file into the content-addressed store and returns it's digest and size
+func storeArtifact(in io.Reader) (string, int64, error) {
+	err := os.MkdirAll(pwd+"/artifacts/sha256", 0750)
+	if err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(pwd+"/artifacts", ".blob-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), in)
+	tmp.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+	dst := artifactPath(digest)
+	if _, err := os.Stat(dst); err == nil {
+		return digest, size, nil
+	}
+	err = os.Chmod(tmp.Name(), 0440)
+	if err != nil {
+		return "", 0, err
+	}
+	err = os.Rename(tmp.Name(), dst)
+	if err != nil {
+		return "", 0, err
+	}
+	return digest, size, nil
+}
+
+// getArtifact resolves a name@version reference, a reference without a version resolves to the latest
+func getArtifact(user, ref string) (*data.Artifact, error) {
+	split := strings.SplitN(ref, "@", 2)
+	artName := split[0]
+	var version string
+	if len(split) == 2 && split[1] != "" && split[1] != "latest" {
+		version = split[1]
+	} else {
+		latest, err := db.HGet(user+"_artifact_versions", artName).Result()
+		if err != nil {
+			return nil, errors.New(artName + " artifact not found")
+		}
+		version = latest
+	}
+	raw, err := db.HGet(user+"_artifacts", artName+"@"+version).Result()
+	if err != nil {
+		return nil, errors.New(artName + "@" + version + " artifact not found")
+	}
+	art := &data.Artifact{}
+	err = json.Unmarshal([]byte(raw), art)
+	if err != nil {
+		return nil, err
+	}
+	return art, nil
+}
+
+// registerArtifact route registers a workspace file as a new version of a named artifact
+func registerArtifact(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	art := &data.Artifact{}
+	if err := c.Bind(art); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !validName.MatchString(art.Name) {
+		c.String(400, "Artifact name can only contain letters, numbers, dots, dashes and underscores")
+		return
+	}
+	if art.File == "" {
+		c.String(400, "No file specified")
+		return
+	}
+	src, err := openWorkspace(name, art.File)
+	if err != nil {
+		c.String(404, art.File+" not found in workspace")
+		return
+	}
+	defer src.Close()
+	digest, size, err := storeArtifact(src)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if art.GitSHA == "" {
+		art.GitSHA, _ = db.HGet(name, "sha").Result()
+	}
+	if art.RunID == "" {
+		art.RunID, _ = db.HGet(name, "run").Result()
+	}
+	art.Version, err = db.HIncrBy(name+"_artifact_versions", art.Name, 1).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	art.File = filepath.Base(workspaceFile(name, art.File))
+	art.Digest = "sha256:" + digest
+	art.Size = size
+	art.Created = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.Marshal(art)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	err = db.HSet(name+"_artifacts", art.Name+"@"+strconv.FormatInt(art.Version, 10), b).Err()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(201, art)
+}
+
+// listArtifacts route lists all registered artifacts, optionally filtered by name
+func listArtifacts(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	filter := c.Query("name")
+	all, err := db.HGetAll(name + "_artifacts").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	arts := []data.Artifact{}
+	for _, raw := range all {
+		var art data.Artifact
+		if err := json.Unmarshal([]byte(raw), &art); err != nil {
+			continue
+		}
+		if filter != "" && art.Name != filter {
+			continue
+		}
+		arts = append(arts, art)
+	}
+	sort.Slice(arts, func(i, j int) bool {
+		if arts[i].Name == arts[j].Name {
+			return arts[i].Version < arts[j].Version
+		}
+		return arts[i].Name < arts[j].Name
+	})
+	c.JSON(200, arts)
+}
+
+// showArtifact route returns the details of a single artifact version
+func showArtifact(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	art, err := getArtifact(name, c.Param("ref"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	c.JSON(200, art)
+}
+
+// downloadArtifact route is for downloading the stored file of an artifact version
+func downloadArtifact(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	art, err := getArtifact(name, c.Param("ref"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	path := artifactPath(strings.TrimPrefix(art.Digest, "sha256:"))
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.String(500, err.Error())
+		return
+	}
+	c.FileAttachment(path, art.File)
+}
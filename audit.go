@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// auditLog appends audit events to the configured sink, the file is opened on the first event
+var auditLog = struct {
+	sync.Mutex
+	file    *os.File
+	webhook chan []byte
+}{}
+
+// auditRecord is an audit event of a request, it's emitted when the handler is done
+type auditRecord struct {
+	data.AuditEvent
+	c *gin.Context
+}
+
+// requestID middleware gives every request a new ID which is passed on to proxied APIs, an X-Request-ID sent by the
+// client can't be trusted so it's only kept as the client request ID
+func requestID(c *gin.Context) {
+	if sent := c.GetHeader("X-Request-ID"); len(sent) <= 64 && validName.MatchString(sent) {
+		c.Set("client_request_id", sent)
+	}
+	id := genToken()
+	c.Request.Header.Set("X-Request-ID", id)
+	c.Set("request_id", id)
+	c.Header("X-Request-ID", id)
+	c.Next()
+}
+
+// remoteIP returns the address of the connection, X-Forwarded-For is sent by the client and can't be trusted
+func remoteIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// audit starts an audit event for an action, emit has to be called when the handler is done
+func audit(c *gin.Context, action, target string) *auditRecord {
+	actor := c.GetString(gin.AuthUserKey)
+	if actor == "" {
+		actor = "anonymous"
+	}
+	a := &auditRecord{c: c, AuditEvent: data.AuditEvent{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		IP:        remoteIP(c),
+		RequestID: c.GetString("request_id"),
+	}}
+	a.detail("client_request_id", c.GetString("client_request_id"))
+	return a
+}
+
+// detail adds a detail to the audit event, empty values are left out
+func (a *auditRecord) detail(k, v string) {
+	if v == "" {
+		return
+	}
+	if a.Details == nil {
+		a.Details = make(map[string]string)
+	}
+	a.Details[k] = v
+}
+
+// emit sets the result from the response status and writes the audit event to the sink
+func (a *auditRecord) emit() {
+	a.Status = a.c.Writer.Status()
+	a.Result = "success"
+	if a.Status >= 400 {
+		a.Result = "failure"
+	}
+	if err := writeAudit(a.AuditEvent); err != nil {
+		logger.Error("writing audit event failed", zap.String("action", a.Action), zap.Error(err))
+	}
+}
+
+// auditFile returns the path of the audit log, a relative Audit.File is in the working directory
+func auditFile() string {
+	if filepath.IsAbs(DamaConfig.Audit.File) {
+		return DamaConfig.Audit.File
+	}
+	return filepath.Join(pwd, DamaConfig.Audit.File)
+}
+
+// writeAudit appends an audit event as a JSON line to the file or stdout, or queues it for the webhook
+func writeAudit(ev data.AuditEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	switch DamaConfig.Audit.Sink {
+	case "stdout":
+		_, err = os.Stdout.Write(line)
+	case "webhook":
+		if auditLog.webhook == nil {
+			auditLog.webhook = make(chan []byte, 1000)
+			go postAudits(auditLog.webhook)
+		}
+		select {
+		case auditLog.webhook <- line:
+		default:
+			logger.Error("audit webhook queue full, dropped event", zap.String("event", string(line)))
+		}
+	default:
+		if auditLog.file == nil {
+			auditLog.file, err = os.OpenFile(auditFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = auditLog.file.Write(line)
+	}
+	return err
+}
+
+// postAudits posts queued audit events to Audit.Webhook, a failed post is tried 3 times
+func postAudits(events <-chan []byte) {
+	cl := &http.Client{Timeout: 10 * time.Second}
+	for line := range events {
+		for try := 1; try <= 3; try++ {
+			resp, err := cl.Post(DamaConfig.Audit.Webhook, "application/json", bytes.NewReader(line))
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 300 {
+					break
+				}
+				err = errors.New("audit webhook responded " + resp.Status)
+			}
+			if try == 3 {
+				logger.Error("posting audit event failed", zap.Error(err), zap.String("event", string(line)))
+				break
+			}
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+	}
+}
+
+// adminAudit route returns audit events from the audit file newest first, filtered by actor, action prefix, target,
+// result, since and until, limit defaults to 100
+func adminAudit(c *gin.Context) {
+	if DamaConfig.Audit.Sink != "" && DamaConfig.Audit.Sink != "file" {
+		c.String(404, "Audit log can only be queried with the file sink")
+		return
+	}
+	since, err := parseSince(c.Query("since"))
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	until, err := parseSince(c.Query("until"))
+	if err != nil {
+		c.String(400, strings.Replace(err.Error(), "since", "until", 1))
+		return
+	}
+	limit := 100
+	if l := c.Query("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.String(400, "limit needs to be a positive number")
+			return
+		}
+	}
+	f, err := os.Open(auditFile())
+	if os.IsNotExist(err) {
+		c.JSON(200, []data.AuditEvent{})
+		return
+	}
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	defer f.Close()
+	actor, action, target, result := c.Query("actor"), c.Query("action"), c.Query("target"), c.Query("result")
+	events := []data.AuditEvent{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev data.AuditEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) != nil {
+			continue
+		}
+		if (actor != "" && ev.Actor != actor) || (action != "" && !strings.HasPrefix(ev.Action, action)) ||
+			(target != "" && ev.Target != target) || (result != "" && ev.Result != result) {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339Nano, ev.Time)
+		if (!since.IsZero() && t.Before(since)) || (!until.IsZero() && t.After(until)) {
+			continue
+		}
+		events = append(events, ev)
+		// Only the newest events are kept
+		if len(events) > limit {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	c.JSON(200, events)
+}
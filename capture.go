@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"github.com/yhat/wsutil"
+)
+
+// redacted replaces the values of redacted fields in captures
+const redacted = "[REDACTED]"
+
+// captureMu serializes writes and rotations of capture files
+var captureMu sync.Mutex
+
+// captureBuffer keeps the first max bytes written to it and drops the rest
+type captureBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write keeps what fits in the buffer and always reports the full length as written
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// captureWriter copies the response body to a buffer while it's written to the client
+type captureWriter struct {
+	gin.ResponseWriter
+	body *captureBuffer
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// validCapture checks the sample rate is between 0 and 1
+func validCapture(cp data.Capture) error {
+	if cp.Sample < 0 || cp.Sample > 1 {
+		return errors.New("Capture sample needs to be between 0 and 1")
+	}
+	return nil
+}
+
+// setCapture keeps the capture settings of the users deployment
+func setCapture(name string, cp data.Capture) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return db.HSet(name, "capture", b).Err()
+}
+
+// getCapture returns the capture settings of the users deployment, nothing is captured without them
+func getCapture(name string) data.Capture {
+	cp := data.Capture{}
+	if raw, _ := db.HGet(name, "capture").Result(); raw != "" {
+		json.Unmarshal([]byte(raw), &cp)
+	}
+	return cp
+}
+
+// capturePath returns the current capture file of the users deployment, rotated files end in .1, .2 and so on
+func capturePath(name string) string {
+	return pwd + "/captures/" + name + "/captures.jsonl"
+}
+
+// redactKey reports if a field is in the redaction rules, field names are matched case insensitive
+func redactKey(redact []string, k string) bool {
+	for _, r := range redact {
+		if strings.EqualFold(r, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactJSON replaces the values of redacted fields at any depth of a decoded JSON value
+func redactJSON(redact []string, v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if redactKey(redact, k) {
+				t[k] = redacted
+			} else {
+				t[k] = redactJSON(redact, val)
+			}
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactJSON(redact, val)
+		}
+	}
+	return v
+}
+
+// redactValues replaces the values of redacted fields in a query string or form body, false is returned when
+// there are redaction rules and it can't be parsed
+func redactValues(redact []string, raw string) (string, bool) {
+	if len(redact) == 0 {
+		return raw, true
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "", false
+	}
+	for k := range values {
+		if redactKey(redact, k) {
+			values[k] = []string{redacted}
+		}
+	}
+	return values.Encode(), true
+}
+
+// captureBody returns a captured body for the capture record, JSON is kept as JSON and forms and text as a string.
+// Binary bodies are left out and a truncated body is marked with a trailing ellipsis. With redaction rules only
+// bodies that are redacted as JSON or a form are kept.
+func captureBody(redact []string, contentType string, b *captureBuffer) interface{} {
+	if b.Len() == 0 || !utf8.Valid(b.Bytes()) {
+		return nil
+	}
+	if !b.truncated {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(b.Bytes()))
+		d.UseNumber()
+		if err := d.Decode(&v); err == nil {
+			return redactJSON(redact, v)
+		}
+	}
+	body := b.String()
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		var ok bool
+		if body, ok = redactValues(redact, body); !ok {
+			return nil
+		}
+	} else if len(redact) > 0 {
+		// A body that couldn't be decoded can't be redacted, so it's left out
+		return nil
+	}
+	if b.truncated {
+		body += "…"
+	}
+	return body
+}
+
+// sampled reports if a request to the owners deployment is captured, websockets aren't captured
+func sampled(c *gin.Context, cp data.Capture) bool {
+	return cp.Sample > 0 && rand.Float64() < cp.Sample && !wsutil.IsWebSocketRequest(c.Request)
+}
+
+// startCapture copies the request and response bodies of a sampled request, the returned done
+// writes the capture when the request is served
+func startCapture(c *gin.Context, owner, consumer string, cp data.Capture) func() {
+	start := time.Now()
+	reqBody := &captureBuffer{max: DamaConfig.Capture.MaxBody}
+	if c.Request.Body != nil {
+		body := c.Request.Body
+		c.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(body, reqBody), body}
+	}
+	w := &captureWriter{ResponseWriter: c.Writer, body: &captureBuffer{max: DamaConfig.Capture.MaxBody}}
+	c.Writer = w
+	// A query string that can't be redacted is left out
+	query, _ := redactValues(cp.Redact, c.Request.URL.RawQuery)
+	reqType := c.GetHeader("Content-Type")
+	return func() {
+		c.Writer = w.ResponseWriter
+		rec := data.CapturedRequest{
+			Time:     start.UTC().Format(time.RFC3339Nano),
+			Method:   c.Request.Method,
+			Path:     c.Request.URL.Path,
+			Query:    query,
+			Consumer: consumer,
+			Status:   w.Status(),
+			Duration: time.Since(start).Seconds(),
+			Request:  captureBody(cp.Redact, reqType, reqBody),
+		}
+		// Compressed responses aren't readable in the capture
+		if enc := w.Header().Get("Content-Encoding"); enc == "" || enc == "identity" {
+			rec.Response = captureBody(cp.Redact, w.Header().Get("Content-Type"), w.body)
+		}
+		if err := writeCapture(owner, rec); err != nil {
+			logger.Error("capture " + owner + ": " + err.Error())
+		}
+	}
+}
+
+// writeCapture appends a capture to the owners capture file and rotates it when it's full
+func writeCapture(owner string, rec data.CapturedRequest) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	captureMu.Lock()
+	defer captureMu.Unlock()
+	path := capturePath(owner)
+	if err := os.MkdirAll(pwd+"/captures/"+owner, 0700); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > DamaConfig.Capture.MaxSize {
+		if err := rotateCaptures(path); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rotateCaptures shifts the capture files by one and drops the oldest past Capture.MaxFiles
+func rotateCaptures(path string) error {
+	keep := DamaConfig.Capture.MaxFiles
+	if keep < 1 {
+		keep = 1
+	}
+	os.Remove(path + "." + strconv.Itoa(keep-1))
+	for i := keep - 2; i >= 1; i-- {
+		os.Rename(path+"."+strconv.Itoa(i), path+"."+strconv.Itoa(i+1))
+	}
+	if keep == 1 {
+		return os.Remove(path)
+	}
+	return os.Rename(path, path+".1")
+}
+
+// captureFiles returns the capture files of the users deployment from oldest to newest
+func captureFiles(name string) []string {
+	path := capturePath(name)
+	var files []string
+	for i := DamaConfig.Capture.MaxFiles - 1; i >= 1; i-- {
+		if _, err := os.Stat(path + "." + strconv.Itoa(i)); err == nil {
+			files = append(files, path+"."+strconv.Itoa(i))
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// downloadCaptures route returns every capture of a deployment as JSON lines from oldest to newest
+func downloadCaptures(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	captureMu.Lock()
+	files := captureFiles(owner)
+	var readers []io.Reader
+	for _, file := range files {
+		// Files opened before a rotation are still read in full after it
+		f, err := os.Open(file)
+		if err != nil {
+			captureMu.Unlock()
+			c.String(500, err.Error())
+			return
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	captureMu.Unlock()
+	if len(readers) == 0 {
+		c.String(404, "No captures")
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+owner+`-captures.jsonl"`)
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(200)
+	io.Copy(c.Writer, io.MultiReader(readers...))
+}
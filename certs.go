@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReload is how often the cert directory is read again for new or renewed certificates
+const certReload = time.Minute
+
+// serverCert is the default certificate of the server, it's parsed once by serverCertificate
+var serverCert struct {
+	once sync.Once
+	leaf *x509.Certificate
+}
+
+// serverCertificate returns the parsed pem certificate of the server, nil when it can't be read
+func serverCertificate() *x509.Certificate {
+	serverCert.once.Do(func() {
+		b, err := ioutil.ReadFile(DamaConfig.HTTPS.Pem)
+		if err != nil {
+			return
+		}
+		if block, _ := pem.Decode(b); block != nil {
+			serverCert.leaf, _ = x509.ParseCertificate(block.Bytes)
+		}
+	})
+	return serverCert.leaf
+}
+
+// certStore picks TLS certificates by SNI from a directory of pem and key pairs, the default certificate is used when none match
+type certStore struct {
+	mu       sync.Mutex
+	dir      string
+	certs    []*tls.Certificate
+	loaded   time.Time
+	fallback *tls.Certificate
+}
+
+// newCertStore loads the default certificate and the certificates in dir
+func newCertStore(dir, pem, key string) (*certStore, error) {
+	fallback, err := tls.LoadX509KeyPair(pem, key)
+	if err != nil {
+		return nil, err
+	}
+	s := &certStore{dir: dir, fallback: &fallback}
+	s.load()
+	return s, nil
+}
+
+// load reads every name.pem or name.crt with a name.key in the directory, pairs that fail to load are logged and skipped
+func (s *certStore) load() {
+	s.loaded = time.Now()
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		logger.Error("reading cert dir: " + err.Error())
+		return
+	}
+	var certs []*tls.Certificate
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		base := filepath.Join(s.dir, strings.TrimSuffix(f.Name(), ext))
+		cert, err := tls.LoadX509KeyPair(base+ext, base+".key")
+		if err != nil {
+			logger.Error("loading cert " + f.Name() + ": " + err.Error())
+			continue
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			logger.Error("parsing cert " + f.Name() + ": " + err.Error())
+			continue
+		}
+		certs = append(certs, &cert)
+	}
+	s.certs = certs
+}
+
+// GetCertificate returns the first certificate valid for the server name, wildcard certificates included
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.loaded) > certReload {
+		s.load()
+	}
+	name := strings.ToLower(hello.ServerName)
+	if name != "" {
+		for _, cert := range s.certs {
+			if cert.Leaf.VerifyHostname(name) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return s.fallback, nil
+}
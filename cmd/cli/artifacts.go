@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// artifactCmd handles the artifact register, ls and dl subcommands
+func artifactCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "register":
+		fs := flag.NewFlagSet("register", flag.ExitOnError)
+		metrics := fs.String("metrics", "", "Metrics JSON file")
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			return errors.New("Usage: dama artifact register <name> <file> [-metrics metrics.json]")
+		}
+		art := data.Artifact{Name: fs.Arg(0), File: fs.Arg(1), GitSHA: strings.TrimSpace(gitRev())}
+		if *metrics != "" {
+			b, err := ioutil.ReadFile(*metrics)
+			if err != nil {
+				return err
+			}
+			err = json.Unmarshal(b, &art.Metrics)
+			if err != nil {
+				return err
+			}
+		}
+		registered, err := registerArtifact(art)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Registered " + registered.Name + "@" + strconv.FormatInt(registered.Version, 10) + " " + registered.Digest)
+	case "ls":
+		var name string
+		if len(args) > 1 {
+			name = args[1]
+		}
+		out, err := artifactDetails(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(out)
+	case "dl":
+		if len(args) != 2 {
+			return errors.New("Usage: dama artifact dl <name[@version]>")
+		}
+		file, err := downloadArtifact(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println("Download complete " + file)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// registerArtifact is used to register a workspace file in the artifact registry
+func registerArtifact(art data.Artifact) (*data.Artifact, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(art)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+"artifacts", b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	registered := &data.Artifact{}
+	err = json.Unmarshal(body, registered)
+	if err != nil {
+		return nil, err
+	}
+	return registered, nil
+}
+
+// artifactDetails makes a request to server to list registered artifacts
+func artifactDetails(name string) (string, error) {
+	req, err := http.NewRequest("GET", server+"artifacts?name="+url.QueryEscape(name), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return "", errors.New(string(body))
+	}
+	var arts []data.Artifact
+	err = json.Unmarshal(body, &arts)
+	if err != nil {
+		return "", err
+	}
+	output := []string{"NAME | VERSION | FILE | SIZE | GIT SHA | RUN | CREATED"}
+	for _, a := range arts {
+		output = append(output, fmt.Sprintf("%s|%d|%s|%d|%s|%s|%s", a.Name, a.Version, a.File, a.Size, a.GitSHA, a.RunID, a.Created))
+	}
+	return columnize.SimpleFormat(output), nil
+}
+
+// downloadArtifact is used to download an artifact file from the registry on server
+func downloadArtifact(ref string) (string, error) {
+	art, err := artifactInfo(ref)
+	if err != nil {
+		return "", err
+	}
+	ref = art.Name + "@" + strconv.FormatInt(art.Version, 10)
+	req, err := http.NewRequest("GET", server+"artifacts/"+url.PathEscape(ref)+"/download", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", errors.New(string(body))
+	}
+	out, err := os.Create(art.File)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return art.File, nil
+}
+
+// artifactInfo makes a request to server for the details of a single artifact
+func artifactInfo(ref string) (*data.Artifact, error) {
+	req, err := http.NewRequest("GET", server+"artifacts/"+url.PathEscape(ref), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(body))
+	}
+	art := &data.Artifact{}
+	err = json.Unmarshal(body, art)
+	if err != nil {
+		return nil, err
+	}
+	return art, nil
+}
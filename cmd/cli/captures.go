@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// captureCmd downloads the sampled requests and responses captured from your deployed API
+func captureCmd(args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	out := fs.String("o", "", "Save the captures to a file instead of printing them")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("Usage: dama capture [-o captures.jsonl]")
+	}
+	req, err := http.NewRequest("GET", server+"deployments/"+url.PathEscape(username)+"/captures", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(string(body))
+	}
+	if *out == "" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, resp.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d bytes of captures to %s\n", n, *out)
+	return nil
+}
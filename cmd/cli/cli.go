@@ -45,6 +45,48 @@ var (
  -deploy        Deploy API and get your unique URI
  -show-api      Show API details: URL, Health and Type
  -show-images   Show images available to use
+ -show-usage    Show workspace usage: Used, Remaining and Limit
+
+Commands: dama <command> [options] <args>
+
+ artifact register <name> <file> [-metrics metrics.json]   Register a workspace file as a new artifact version
+ artifact ls [name]                                         List registered artifacts
+ artifact dl <name[@version]>                               Download an artifact to your local computer
+ job submit [-file dama.yml] [-img image] [-wait]           Run dama.yml as a non-interactive batch job
+ job ls                                                     List batch jobs
+ job status <id>                                            Show exit code and duration of a batch job
+ job logs <id> [-stream output|stdout|stderr]               Show the logs of a batch job
+ schedule add [-file dama.yml]                               Run dama.yml as a batch job on it's schedule
+ schedule ls                                                List schedules
+ schedule show <name>                                       Show a schedule and it's run history
+ schedule rm <name>                                         Delete a schedule
+ pipeline run [-file dama.yml] [-wait]                      Run the steps of dama.yml as a pipeline
+ pipeline ls                                                List pipelines
+ pipeline status <id>                                       Show the status of every step of a pipeline
+ exp ls [project]                                           List projects or the runs of a project with params and metrics
+ exp compare <project> [timestamp...]                       Compare params and metrics of runs side by side
+ logs [-f] [-target deploy|sandbox] [-since 10m] [-tail 100] Show or follow the logs of your deployed API or sandbox
+ exec [-timeout 60] [-i] -- <command> [args]               Run a command in your sandbox and exit with it's exit code
+ share <user> [-readonly]                                   Let another user join your terminal session
+ share <user> -revoke                                       Revoke a users access to your terminal session
+ share [-audit]                                             List who your session is shared with or who typed what
+ attach <user>                                              Join the terminal session another user shared with you
+ replay [<id>] [-speed 2] [-idle 2s] [-o file.cast]         List your recorded terminal sessions or replay one
+ forward <local port>:<sandbox port>                        Tunnel a local port to a port inside your sandbox
+ domain add <host>                                          Route a host name to your deployed API
+ domain ls                                                  List host names routed to your deployed API
+ domain rm <host>                                           Stop routing a host name to your deployed API
+ key add <name>                                             Create a consumer API key for your deployed API
+ key ls                                                     List consumer API keys of your deployed API
+ key rm <name>                                              Revoke a consumer API key
+ capture [-o captures.jsonl]                                Download sampled requests and responses of your deployed API
+ top [-target deploy|sandbox] [-once]                      Show live CPU, memory, network and disk usage and OOM kills
+ s3                                                         Show the last S3 pulls and pushes of your workspace
+ webhook add [-events e1,e2] [-secret s] <name> <url>       Send deploy, job and sandbox expiry events to a URL
+ webhook ls                                                 List your webhooks
+ webhook rm <name>                                          Delete a webhook
+ webhook test <name>                                        Send a ping to a webhook and show the delivery
+ webhook log                                                Show the last deliveries to your webhooks
 
 `
 )
@@ -59,6 +101,36 @@ func gitRev() string {
 	return string(gitOut)
 }
 
+// loadDamafile reads a dama.yml if it exists and sets the git SHA, project, TIMESTAMP and PROJECT env
+func loadDamafile(filep string) (data.Damafile, error) {
+	f := data.Damafile{}
+	rFile, err := ioutil.ReadFile(filep)
+	if err == nil {
+		err = yaml.Unmarshal(rFile, &f)
+		if err != nil {
+			return f, err
+		}
+	}
+	if f.Git.SHA == "" {
+		f.Git.SHA = strings.TrimSpace(gitRev())
+	}
+	if f.Project == "" {
+		wd, _ := os.Getwd()
+		f.Project = filepath.Base(wd)
+	}
+	t := time.Now()
+	if f.TimeFormat == "" {
+		f.Env = append(f.Env, "TIMESTAMP="+timeutil.Strftime(&t, strf))
+	} else {
+		f.Env = append(f.Env, "TIMESTAMP="+timeutil.Strftime(&t, f.TimeFormat))
+	}
+	f.Env = append(f.Env, "PROJECT="+f.Project)
+	pipSplit := strings.Split(f.Pip, "\n")
+	pipJoin := strings.Join(pipSplit, " ")
+	f.Pip = pipJoin
+	return f, nil
+}
+
 // postEnv is used to post new environment variables to server
 func postEnv(e string) (string, error) {
 	env := data.Damafile{Env: strings.Split(e, ",")}
@@ -316,6 +388,55 @@ func imgDetails() string {
 	return columnize.SimpleFormat(output)
 }
 
+// usageDetails makes a request to server to get the workspace quota usage
+func usageDetails() string {
+	req, err := http.NewRequest("GET", server+"workspace/usage", nil)
+	if err != nil {
+		return err.Error()
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "Error retrieving usage details"
+	}
+	if resp.StatusCode != 200 {
+		return string(body)
+	}
+	var u data.Usage
+	err = json.Unmarshal(body, &u)
+	if err != nil {
+		return err.Error()
+	}
+	output := []string{"USED | REMAINING | LIMIT", fmt.Sprintf("%d|%d|%d", u.Used, u.Remaining, u.Limit)}
+	return columnize.SimpleFormat(output)
+}
+
+// commands are the subcommands available after the options
+var commands = map[string]func([]string) error{
+	"artifact": artifactCmd,
+	"job":      jobCmd,
+	"schedule": scheduleCmd,
+	"pipeline": pipelineCmd,
+	"exp":      expCmd,
+	"logs":     logsCmd,
+	"exec":     execCmd,
+	"share":    shareCmd,
+	"attach":   attachCmd,
+	"replay":   replayCmd,
+	"forward":  forwardCmd,
+	"domain":   domainCmd,
+	"key":      keyCmd,
+	"capture":  captureCmd,
+	"top":      topCmd,
+	"s3":       s3Cmd,
+	"webhook":  webhookCmd,
+}
+
 func main() {
 	run := flag.Bool("run", false, "New container")
 	file := flag.String("file", "", "File location")
@@ -327,6 +448,7 @@ func main() {
 	deploy := flag.Bool("deploy", false, "Deploy API")
 	showAPI := flag.Bool("show-api", false, "Show API details")
 	showImgs := flag.Bool("show-images", false, "Show image details")
+	showUsage := flag.Bool("show-usage", false, "Show workspace usage")
 	flag.Usage = func() {
 		fmt.Println(usage)
 	}
@@ -364,11 +486,29 @@ func main() {
 		Timeout:   time.Second * 600,
 	}
 
+	if flag.NArg() > 0 {
+		cmd, ok := commands[flag.Arg(0)]
+		if !ok {
+			fmt.Println(usage)
+			os.Exit(1)
+		}
+		if err := cmd(flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if *showImgs {
 		fmt.Println(imgDetails())
 		os.Exit(0)
 	}
 
+	if *showUsage {
+		fmt.Println(usageDetails())
+		os.Exit(0)
+	}
+
 	if *showAPI {
 		fmt.Println(apiDetails(key))
 		os.Exit(0)
@@ -413,23 +553,16 @@ func main() {
 	match, _ := regexp.MatchString("dama.yml$", *file)
 	_, err = os.Stat("./dama.yml")
 	if *run && *file == "" || *run && *file != "" || match && os.IsNotExist(err) || *deploy {
-		var rFile []byte
 		var filep string
 		if match {
 			filep = *file
 		} else {
 			filep = "./dama.yml"
 		}
-		rFile, err = ioutil.ReadFile(filep)
-		if err == nil {
-			e := yaml.Unmarshal(rFile, &f)
-			if e != nil {
-				fmt.Println(e.Error())
-				os.Exit(1)
-			}
-		}
-		if f.Git.SHA == "" {
-			f.Git.SHA = strings.TrimSpace(gitRev())
+		f, err = loadDamafile(filep)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
 		}
 		if f.Port == "" {
 			port = "5000"
@@ -439,22 +572,11 @@ func main() {
 		if *img == "" {
 			*img = f.Image
 		}
-		if f.Project == "" {
-			wd, _ := os.Getwd()
-			f.Project = filepath.Base(wd)
-		}
-		t := time.Now()
-		if f.TimeFormat == "" {
-			f.Env = append(f.Env, "TIMESTAMP="+timeutil.Strftime(&t, strf))
-		} else {
-			f.Env = append(f.Env, "TIMESTAMP="+timeutil.Strftime(&t, f.TimeFormat))
-		}
-		f.Env = append(f.Env, "PROJECT="+f.Project)
-		pipSplit := strings.Split(f.Pip, "\n")
-		pipJoin := strings.Join(pipSplit, " ")
-		f.Pip = pipJoin
 		if *deploy {
+			done := make(chan struct{})
+			go watchQueue(done)
 			uri, err := deployAPI(f)
+			close(done)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -467,6 +589,10 @@ func main() {
 			fmt.Println(err)
 			os.Exit(1)
 		}
+		for _, s := range f.Services {
+			fmt.Println(s + ": " + server + "sandbox/" + username + "/" + s + "/")
+		}
+		go watchQueue(nil)
 		if err := cli.Loop(*run, true, "build", *img, username, key, port); err != nil {
 			fmt.Println("Environment no longer available, try\ndama -new")
 			os.Exit(1)
@@ -474,6 +600,7 @@ func main() {
 		os.Exit(0)
 	}
 	if *run && *file != "" {
+		go watchQueue(nil)
 		if err := cli.Loop(*run, true, *file, *img, username, key, port); err != nil {
 			fmt.Println("Environment no longer available, try\ndama -new")
 			os.Exit(1)
@@ -489,6 +616,7 @@ func main() {
 		fmt.Println(usage)
 		os.Exit(1)
 	}
+	go watchQueue(nil)
 	if err := cli.Loop(*run, *new, *file, *img, username, key, port); err != nil {
 		fmt.Println("Environment no longer available, try\ndama -new")
 		os.Exit(1)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// domainCmd handles the domain add, ls and rm subcommands
+func domainCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			return errors.New("Usage: dama domain add <host>")
+		}
+		d, err := postDomain(data.Domain{Host: args[1]})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Routing https://" + d.Host + "/ to your deployed API")
+	case "ls":
+		var domains []data.Domain
+		err := getJSON("domains", &domains)
+		if err != nil {
+			return err
+		}
+		output := []string{"HOST | CUSTOM"}
+		for _, d := range domains {
+			output = append(output, fmt.Sprintf("%s|%t", d.Host, d.Custom))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("Usage: dama domain rm <host>")
+		}
+		req, err := http.NewRequest("DELETE", server+"domains/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, key)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			return errors.New(string(body))
+		}
+		fmt.Println("Removed " + args[1])
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// postDomain is used to route a host name to your deployed API
+func postDomain(d data.Domain) (*data.Domain, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(d)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+"domains", b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	domain := &data.Domain{}
+	err = json.Unmarshal(body, domain)
+	if err != nil {
+		return nil, err
+	}
+	return domain, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+)
+
+// execCmd runs a command in the sandbox container and exits with it's exit code
+func execCmd(args []string) error {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	timeout := fs.Int("timeout", 0, "Seconds before the command is stopped, defaults to the server limit")
+	stdin := fs.Bool("i", false, "Send stdin to the command")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("Usage: dama exec [-timeout 60] [-i] -- <command> [args]")
+	}
+	ex := data.Exec{Cmd: fs.Args(), Timeout: *timeout}
+	if *stdin {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		ex.Stdin = string(b)
+	}
+	code, err := execRemote(ex)
+	if err != nil {
+		return err
+	}
+	os.Exit(code)
+	return nil
+}
+
+// execRemote posts a command to the server, writes it's output to stdout and stderr and returns the exit code
+func execRemote(ex data.Exec) (int, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(ex)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", server+"exec", b)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	// Commands can run longer than the client timeout
+	cl := &http.Client{Transport: c.Transport}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return 0, errors.New(string(body))
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		frame := data.ExecFrame{}
+		err = json.Unmarshal(scanner.Bytes(), &frame)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case frame.ExitCode != nil:
+			if frame.Error != "" {
+				fmt.Fprintln(os.Stderr, frame.Error)
+			}
+			return *frame.ExitCode, nil
+		case frame.Stream == "stderr":
+			fmt.Fprint(os.Stderr, frame.Data)
+		default:
+			fmt.Fprint(os.Stdout, frame.Data)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("Connection closed before the command finished")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// expCmd handles the exp ls and compare subcommands
+func expCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "ls":
+		if len(args) == 1 {
+			var exps []data.Experiment
+			err := getJSON("experiments", &exps)
+			if err != nil {
+				return err
+			}
+			output := []string{"PROJECT | RUNS"}
+			for _, e := range exps {
+				output = append(output, fmt.Sprintf("%s|%d", e.Project, e.Runs))
+			}
+			fmt.Println(columnize.SimpleFormat(output))
+			return nil
+		}
+		var runs []data.Run
+		err := getJSON("experiments/"+url.PathEscape(args[1]), &runs)
+		if err != nil {
+			return err
+		}
+		output := []string{"TIMESTAMP | RUN | PARAMS | METRICS"}
+		for _, r := range runs {
+			output = append(output, r.Timestamp+"|"+r.RunID+"|"+joinValues(r.Params)+"|"+joinValues(floatValues(r.Metrics)))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "compare":
+		if len(args) < 2 {
+			return errors.New("Usage: dama exp compare <project> [timestamp...]")
+		}
+		var runs []data.Run
+		err := getJSON("experiments/"+url.PathEscape(args[1])+"?runs="+url.QueryEscape(strings.Join(args[2:], ",")), &runs)
+		if err != nil {
+			return err
+		}
+		fmt.Println(compareRuns(runs))
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// compareRuns formats params and metrics of runs side by side, one column per run
+func compareRuns(runs []data.Run) string {
+	params := make(map[string]bool)
+	metrics := make(map[string]bool)
+	header := "NAME"
+	for _, r := range runs {
+		header += " | " + r.Timestamp
+		for k := range r.Params {
+			params[k] = true
+		}
+		for k := range r.Metrics {
+			metrics[k] = true
+		}
+	}
+	output := []string{header}
+	for _, k := range sortedKeys(params) {
+		row := "param " + k
+		for _, r := range runs {
+			row += "|" + fmt.Sprint(valueOrDash(r.Params[k]))
+		}
+		output = append(output, row)
+	}
+	for _, k := range sortedKeys(metrics) {
+		row := "metric " + k
+		for _, r := range runs {
+			if v, ok := r.Metrics[k]; ok {
+				row += "|" + fmt.Sprint(v)
+			} else {
+				row += "|-"
+			}
+		}
+		output = append(output, row)
+	}
+	return columnize.SimpleFormat(output)
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueOrDash returns a dash for missing values in a comparison
+func valueOrDash(v interface{}) interface{} {
+	if v == nil {
+		return "-"
+	}
+	return v
+}
+
+// floatValues converts metric values for joinValues
+func floatValues(m map[string]float64) map[string]interface{} {
+	values := make(map[string]interface{})
+	for k, v := range m {
+		values[k] = v
+	}
+	return values
+}
+
+// joinValues formats a map as sorted key=value pairs
+func joinValues(m map[string]interface{}) string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// forwardCmd listens on a local port and tunnels every connection to a port inside the sandbox
+func forwardCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: dama forward <local port>:<sandbox port>")
+	}
+	split := strings.SplitN(args[0], ":", 2)
+	local, remote := split[0], split[0]
+	if len(split) == 2 {
+		remote = split[1]
+	}
+	for _, p := range []string{local, remote} {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			return errors.New("Ports need to be between 1 and 65535")
+		}
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:"+local)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	fmt.Println("Forwarding localhost:" + local + " to sandbox port " + remote)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go tunnel(conn, remote)
+	}
+}
+
+// tunnel copies a local connection to and from a sandbox port over a websocket
+func tunnel(conn net.Conn, port string) {
+	defer conn.Close()
+	target, err := url.Parse(server)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if target.Scheme == "http" {
+		target.Scheme = "ws"
+	} else {
+		target.Scheme = "wss"
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/forward"
+	target.RawQuery = url.Values{"port": {port}}.Encode()
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Proxy: http.ProxyFromEnvironment}
+	header := http.Header{}
+	header.Add("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+key)))
+	ws, resp, err := dialer.Dial(target.String(), header)
+	if err != nil {
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			fmt.Println(strings.TrimSpace(string(body)))
+			return
+		}
+		fmt.Println(err)
+		return
+	}
+	defer ws.Close()
+
+	go func() {
+		defer ws.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+		}
+	}()
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return
+		}
+	}
+}
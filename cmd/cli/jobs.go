@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// jobCmd handles the job submit, ls, status and logs subcommands
+func jobCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "submit":
+		fs := flag.NewFlagSet("submit", flag.ExitOnError)
+		file := fs.String("file", "./dama.yml", "dama.yml location")
+		img := fs.String("img", "", "Specify image")
+		wait := fs.Bool("wait", false, "Wait for job to finish and exit with it's exit code")
+		fs.Parse(args[1:])
+		f, err := loadDamafile(*file)
+		if err != nil {
+			return err
+		}
+		if *img != "" {
+			f.Image = *img
+		}
+		job, err := submitJob(f)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Submitted job " + job.ID)
+		if *wait {
+			done := make(chan struct{})
+			go watchQueue(done)
+			for job.Status == "queued" || job.Status == "running" {
+				time.Sleep(time.Second * 5)
+				job, err = jobInfo(job.ID)
+				if err != nil {
+					return err
+				}
+			}
+			close(done)
+			err = jobOutput(job.ID, "output")
+			if err != nil {
+				return err
+			}
+			fmt.Println(jobDetails([]data.Job{*job}))
+			os.Exit(job.ExitCode)
+		}
+	case "ls":
+		jobs, err := listJobs()
+		if err != nil {
+			return err
+		}
+		fmt.Println(jobDetails(jobs))
+	case "status":
+		if len(args) != 2 {
+			return errors.New("Usage: dama job status <id>")
+		}
+		job, err := jobInfo(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(jobDetails([]data.Job{*job}))
+	case "logs":
+		fs := flag.NewFlagSet("logs", flag.ExitOnError)
+		stream := fs.String("stream", "output", "output, stdout or stderr")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("Usage: dama job logs <id> [-stream output|stdout|stderr]")
+		}
+		return jobOutput(fs.Arg(0), *stream)
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// jobDetails formats job records in columns
+func jobDetails(jobs []data.Job) string {
+	output := []string{"ID | PROJECT | IMAGE | STATUS | EXIT CODE | DURATION | SUBMITTED"}
+	for _, j := range jobs {
+		duration := time.Duration(j.Duration * float64(time.Second)).Round(time.Second).String()
+		output = append(output, fmt.Sprintf("%s|%s|%s|%s|%d|%s|%s", j.ID, j.Project, j.Image, j.Status, j.ExitCode, duration, j.Submitted))
+	}
+	return columnize.SimpleFormat(output)
+}
+
+// submitJob is used to submit a dama.yml to the server as a batch job
+func submitJob(t data.Damafile) (*data.Job, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+"jobs", b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	job := &data.Job{}
+	err = json.Unmarshal(body, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// listJobs makes a request to server for all batch job records
+func listJobs() ([]data.Job, error) {
+	req, err := http.NewRequest("GET", server+"jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(body))
+	}
+	var jobs []data.Job
+	err = json.Unmarshal(body, &jobs)
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// jobInfo makes a request to server for a single batch job record
+func jobInfo(id string) (*data.Job, error) {
+	req, err := http.NewRequest("GET", server+"jobs/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return nil, errors.New(string(body))
+	}
+	job := &data.Job{}
+	err = json.Unmarshal(body, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// jobOutput writes the logs of a batch job to stdout
+func jobOutput(id, stream string) error {
+	req, err := http.NewRequest("GET", server+"jobs/"+url.PathEscape(id)+"/logs?stream="+url.QueryEscape(stream), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(string(body))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
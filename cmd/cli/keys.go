@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// keyCmd handles the key add, ls and rm subcommands for the consumer API keys of your deployment
+func keyCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	path := "deployments/" + url.PathEscape(username) + "/keys"
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			return errors.New("Usage: dama key add <name>")
+		}
+		k, err := postAPIKey(path, data.APIKey{Name: args[1]})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created API key " + k.Name + ", it won't be shown again\n" + k.Key)
+	case "ls":
+		var keys []data.APIKey
+		err := getJSON(path, &keys)
+		if err != nil {
+			return err
+		}
+		output := []string{"NAME | PREFIX | CREATED"}
+		for _, k := range keys {
+			output = append(output, k.Name+"|"+k.Prefix+"|"+k.Created)
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("Usage: dama key rm <name>")
+		}
+		req, err := http.NewRequest("DELETE", server+path+"/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, key)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			return errors.New(string(body))
+		}
+		fmt.Println("Revoked API key " + args[1])
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// postAPIKey is used to create a consumer API key for your deployment
+func postAPIKey(path string, k data.APIKey) (*data.APIKey, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(k)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+path, b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	apiKey := &data.APIKey{}
+	err = json.Unmarshal(body, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
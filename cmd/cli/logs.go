@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// logsCmd streams the logs of the deployed API or sandbox container
+func logsCmd(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("f", false, "Follow log output")
+	target := fs.String("target", "deploy", "deploy or sandbox")
+	since := fs.String("since", "", "Show logs since a duration like 10m, RFC3339 time or unix timestamp")
+	tail := fs.String("tail", "all", "Number of lines to show from the end of the logs")
+	timestamps := fs.Bool("t", false, "Show timestamps")
+	container := fs.String("container", "", "Show the kept logs of a removed container by it's ID")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("Usage: dama logs [-f] [-target deploy|sandbox] [-since 10m] [-tail 100] [-t] [-container id]")
+	}
+	q := url.Values{}
+	q.Set("target", *target)
+	q.Set("tail", *tail)
+	if *since != "" {
+		q.Set("since", *since)
+	}
+	if *follow {
+		q.Set("follow", "true")
+	}
+	if *timestamps {
+		q.Set("timestamps", "true")
+	}
+	if *container != "" {
+		q.Set("container", *container)
+	}
+	if !*follow {
+		resp, err := openLogs(q)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	// Timestamps are always asked for when following to know where to reconnect from
+	q.Set("timestamps", "true")
+	var last time.Time
+	for {
+		resp, err := openLogs(q)
+		if err != nil {
+			return err
+		}
+		last, err = copyLogs(os.Stdout, resp.Body, last, *timestamps)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		// The server ends following before it's write timeout, the trailer is only set when the container still runs
+		if resp.Trailer.Get("X-Dama-Reconnect") != "true" {
+			return nil
+		}
+		if !last.IsZero() {
+			q.Set("since", last.Format(time.RFC3339Nano))
+			q.Set("tail", "all")
+		}
+	}
+}
+
+// openLogs requests the logs stream
+func openLogs(q url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", server+"logs?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, key)
+	// Following logs can take longer than the client timeout
+	cl := &http.Client{Transport: c.Transport}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.New(string(body))
+	}
+	return resp, nil
+}
+
+// copyLogs writes timestamped log lines newer than last and returns the timestamp of the last line written,
+// the timestamps are removed unless they were asked for
+func copyLogs(w io.Writer, r io.Reader, last time.Time, timestamps bool) (time.Time, error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			split := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+			if t, perr := time.Parse(time.RFC3339Nano, split[0]); perr == nil {
+				// Reconnecting with since repeats the lines of the last second
+				if !t.After(last) {
+					continue
+				}
+				last = t
+				if !timestamps {
+					line = strings.TrimPrefix(line, split[0])
+					line = strings.TrimPrefix(line, " ")
+				}
+			}
+			if _, werr := io.WriteString(w, line); werr != nil {
+				return last, werr
+			}
+		}
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+	}
+}
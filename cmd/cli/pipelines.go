@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// pipelineCmd handles the pipeline run, ls and status subcommands
+func pipelineCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "run":
+		fs := flag.NewFlagSet("run", flag.ExitOnError)
+		file := fs.String("file", "./dama.yml", "dama.yml location")
+		wait := fs.Bool("wait", false, "Wait for pipeline to finish")
+		fs.Parse(args[1:])
+		f, err := loadDamafile(*file)
+		if err != nil {
+			return err
+		}
+		p, err := postPipeline(f)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Started pipeline " + p.ID)
+		if *wait {
+			for p.Status == "running" {
+				time.Sleep(time.Second * 5)
+				err = getJSON("pipelines/"+url.PathEscape(p.ID), p)
+				if err != nil {
+					return err
+				}
+			}
+			fmt.Println(stepDetails(p))
+			if p.Status != "succeeded" {
+				os.Exit(1)
+			}
+		}
+	case "ls":
+		var pipelines []data.Pipeline
+		err := getJSON("pipelines", &pipelines)
+		if err != nil {
+			return err
+		}
+		output := []string{"ID | PROJECT | STATUS | STEPS | SUBMITTED | FINISHED"}
+		for _, p := range pipelines {
+			output = append(output, fmt.Sprintf("%s|%s|%s|%d|%s|%s", p.ID, p.Project, p.Status, len(p.Steps), p.Submitted, p.Finished))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "status":
+		if len(args) != 2 {
+			return errors.New("Usage: dama pipeline status <id>")
+		}
+		p := &data.Pipeline{}
+		err := getJSON("pipelines/"+url.PathEscape(args[1]), p)
+		if err != nil {
+			return err
+		}
+		fmt.Println(stepDetails(p))
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// stepDetails formats the status of every step of a pipeline in columns
+func stepDetails(p *data.Pipeline) string {
+	output := []string{"STEP | STATUS | JOB | EXIT CODE | REASON"}
+	for _, s := range p.Steps {
+		output = append(output, fmt.Sprintf("%s|%s|%s|%d|%s", s.Name, s.Status, s.Job, s.ExitCode, s.Reason))
+	}
+	return "Pipeline " + p.ID + " " + p.Status + "\n" + columnize.SimpleFormat(output)
+}
+
+// postPipeline is used to start a pipeline from the steps of a dama.yml
+func postPipeline(t data.Damafile) (*data.Pipeline, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+"pipelines", b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	p := &data.Pipeline{}
+	err = json.Unmarshal(body, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
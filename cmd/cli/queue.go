@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/perlogix/dama/data"
+)
+
+// watchQueue polls the server and prints the queue position while a container is waiting to start,
+// it stops once the request leaves the queue, done is closed or nothing was queued after a few polls
+func watchQueue(done <-chan struct{}) {
+	var seen bool
+	last := make(map[string]int)
+	for i := 0; ; i++ {
+		var entries []data.QueueEntry
+		if err := getJSON("queue", &entries); err != nil {
+			return
+		}
+		if len(entries) == 0 && (seen || i >= 3) {
+			return
+		}
+		for _, e := range entries {
+			seen = true
+			if last[e.ID] != e.Position {
+				fmt.Printf("Waiting in queue to start %s, position %d\n", e.Kind, e.Position)
+				last[e.ID] = e.Position
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
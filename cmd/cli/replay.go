@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/perlogix/dama/data"
+	gottyclient "github.com/perlogix/dama/gotty-client"
+	"github.com/ryanuber/columnize"
+)
+
+// replayCmd lists your recorded terminal sessions, or replays or downloads one of them
+func replayCmd(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "Playback speed")
+	idle := fs.Duration("idle", 2*time.Second, "Shorten pauses longer than this, 0 keeps them")
+	out := fs.String("o", "", "Save the asciicast file instead of replaying it")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		var recs []data.Recording
+		err := getJSON("sessions", &recs)
+		if err != nil {
+			return err
+		}
+		output := []string{"ID | STARTED | DURATION | SIZE"}
+		for _, r := range recs {
+			duration := "running"
+			if r.Finished != "" {
+				duration = time.Duration(r.Duration * float64(time.Second)).Round(time.Second).String()
+			}
+			output = append(output, fmt.Sprintf("%s|%s|%s|%d", r.ID, r.Started, duration, r.Size))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+		return nil
+	}
+	if fs.NArg() != 1 {
+		return errors.New("Usage: dama replay [<id> [-speed 2] [-idle 2s] [-o session.cast]]")
+	}
+	req, err := http.NewRequest("GET", server+"sessions/"+url.PathEscape(fs.Arg(0)), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(string(body))
+	}
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(f, resp.Body)
+		return err
+	}
+	cli, err := gottyclient.NewClient(server)
+	if err != nil {
+		return err
+	}
+	return cli.Replay(resp.Body, *speed, *idle)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// s3Cmd shows the last S3 pulls and pushes of your workspace and why they failed
+func s3Cmd(args []string) error {
+	if len(args) != 0 {
+		return errors.New("Usage: dama s3")
+	}
+	var ts []data.S3Transfer
+	err := getJSON("s3", &ts)
+	if err != nil {
+		return err
+	}
+	output := []string{"TIME | DIRECTION | BUCKET | STATUS | OBJECTS | DURATION | ERROR"}
+	for _, t := range ts {
+		output = append(output, t.Time+"|"+t.Direction+"|"+t.Bucket+"|"+t.Status+"|"+strconv.Itoa(t.Objects)+"|"+
+			fmt.Sprintf("%.1fs", t.Duration)+"|"+t.Error)
+	}
+	fmt.Println(columnize.SimpleFormat(output))
+	return nil
+}
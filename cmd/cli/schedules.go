@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// scheduleCmd handles the schedule add, ls, show and rm subcommands
+func scheduleCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("add", flag.ExitOnError)
+		file := fs.String("file", "./dama.yml", "dama.yml location")
+		fs.Parse(args[1:])
+		f, err := loadDamafile(*file)
+		if err != nil {
+			return err
+		}
+		if f.Schedule == "" {
+			return errors.New("No schedule in " + *file)
+		}
+		sched, err := postSchedule(f)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Scheduled " + sched.Name + " next run at " + sched.Next)
+	case "ls":
+		var scheds []data.Schedule
+		err := getJSON("schedules", &scheds)
+		if err != nil {
+			return err
+		}
+		output := []string{"NAME | SCHEDULE | REDEPLOY | LAST RUN | LAST JOB | NEXT RUN"}
+		for _, s := range scheds {
+			output = append(output, fmt.Sprintf("%s|%s|%t|%s|%s|%s", s.Name, s.Damafile.Schedule, s.Damafile.Redeploy, s.LastRun, s.LastJob, s.Next))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "show":
+		if len(args) != 2 {
+			return errors.New("Usage: dama schedule show <name>")
+		}
+		sched := data.Schedule{}
+		err := getJSON("schedules/"+url.PathEscape(args[1]), &sched)
+		if err != nil {
+			return err
+		}
+		fmt.Println(sched.Name + " runs on " + sched.Damafile.Schedule + ", next run at " + sched.Next)
+		fmt.Println(jobDetails(sched.Runs))
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("Usage: dama schedule rm <name>")
+		}
+		req, err := http.NewRequest("DELETE", server+"schedules/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, key)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			return errors.New(string(body))
+		}
+		fmt.Println("Deleted schedule " + args[1])
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// postSchedule is used to create or replace a schedule from a dama.yml
+func postSchedule(t data.Damafile) (*data.Schedule, error) {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(t)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", server+"schedules", b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return nil, errors.New(string(body))
+	}
+	sched := &data.Schedule{}
+	err = json.Unmarshal(body, sched)
+	if err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+// getJSON makes an authenticated GET request to server and unmarshals the JSON response
+func getJSON(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return errors.New(string(body))
+	}
+	return json.Unmarshal(body, v)
+}
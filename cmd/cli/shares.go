@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	gottyclient "github.com/perlogix/dama/gotty-client"
+	"github.com/ryanuber/columnize"
+)
+
+// shareCmd grants, revokes and lists access to your terminal session, or shows who typed what in it
+func shareCmd(args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	readonly := fs.Bool("readonly", false, "Only let the user watch the session")
+	revoke := fs.Bool("revoke", false, "Revoke the users access")
+	audit := fs.Bool("audit", false, "Show who typed what in your shared sessions")
+	fs.Parse(args)
+	var user string
+	if fs.NArg() > 0 {
+		// Flags are allowed after the user too
+		user = fs.Arg(0)
+		fs.Parse(fs.Args()[1:])
+	}
+	switch {
+	case *audit:
+		var events []data.InputEvent
+		err := getJSON("shares/audit", &events)
+		if err != nil {
+			return err
+		}
+		output := []string{"TIME | USER | INPUT"}
+		for _, e := range events {
+			output = append(output, fmt.Sprintf("%s|%s|%q", e.Time, e.User, e.Input))
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case user == "":
+		var shares []data.Share
+		err := getJSON("shares", &shares)
+		if err != nil {
+			return err
+		}
+		output := []string{"USER | ACCESS | CREATED"}
+		for _, s := range shares {
+			access := "read-write"
+			if s.ReadOnly {
+				access = "read-only"
+			}
+			output = append(output, s.User+"|"+access+"|"+s.Created)
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case *revoke:
+		req, err := http.NewRequest("DELETE", server+"shares/"+url.PathEscape(user), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, key)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			return errors.New(string(body))
+		}
+		fmt.Println("Revoked access for " + user)
+	default:
+		err := postShare(data.Share{User: user, ReadOnly: *readonly})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Shared your session with " + user + ", they can join with\ndama attach " + username)
+	}
+	return nil
+}
+
+// postShare is used to grant a user access to your terminal session
+func postShare(s data.Share) error {
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(s)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", server+"shares", b)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 201 {
+		return errors.New(string(body))
+	}
+	return nil
+}
+
+// attachCmd joins the terminal session another user shared with you
+func attachCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: dama attach <user>")
+	}
+	cli, err := gottyclient.NewClient(server)
+	if err != nil {
+		return err
+	}
+	cli.Attach = args[0]
+	if err := cli.Loop(false, false, "", "", username, key, ""); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// topCmd shows live CPU, memory, network and block IO usage of your containers and their recent OOM kills
+func topCmd(args []string) error {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	target := fs.String("target", "", "deploy or sandbox, all containers when empty")
+	once := fs.Bool("once", false, "Print one sample and exit")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("Usage: dama top [-target deploy|sandbox] [-once]")
+	}
+	q := url.Values{}
+	if *target != "" {
+		q.Set("target", *target)
+	}
+	if *once {
+		q.Set("stream", "false")
+	}
+	resp, err := openStats(q)
+	if err != nil {
+		return err
+	}
+	var ooms []data.OOMEvent
+	getJSON("stats/oom", &ooms)
+
+	frames := make(chan data.ContainerStats)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(frames)
+		for {
+			dec := json.NewDecoder(resp.Body)
+			var err error
+			for {
+				var frame data.ContainerStats
+				if err = dec.Decode(&frame); err != nil {
+					break
+				}
+				frames <- frame
+			}
+			resp.Body.Close()
+			if *once {
+				if err == io.EOF {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+			// The server ends streams before it's write timeout, they're reopened until every container stopped
+			time.Sleep(time.Second)
+			resp, err = openStats(q)
+			if err != nil {
+				if err == errNoContainer {
+					err = nil
+				}
+				errc <- err
+				return
+			}
+		}
+	}()
+	latest := map[string]data.ContainerStats{}
+	if *once {
+		for frame := range frames {
+			latest[frame.Target+frame.Job] = frame
+		}
+		if err := <-errc; err != nil {
+			return err
+		}
+		fmt.Println(statsTable(latest, ooms))
+		return nil
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				return <-errc
+			}
+			latest[frame.Target+frame.Job] = frame
+		case <-ticker.C:
+			// Clear the screen and redraw from the top left
+			fmt.Print("\033[H\033[2J")
+			fmt.Println(statsTable(latest, ooms))
+		}
+	}
+}
+
+// errNoContainer is returned by openStats when none of the containers is running
+var errNoContainer = errors.New("No container running")
+
+// openStats requests the stats stream
+func openStats(q url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", server+"stats?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, key)
+	// Streaming stats can take longer than the client timeout
+	cl := &http.Client{Transport: c.Transport}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == 404 {
+			return nil, errNoContainer
+		}
+		return nil, errors.New(string(body))
+	}
+	return resp, nil
+}
+
+// statsTable formats the latest sample of each container and the last OOM kills
+func statsTable(latest map[string]data.ContainerStats, ooms []data.OOMEvent) string {
+	names := make([]string, 0, len(latest))
+	for name := range latest {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	output := []string{"TARGET | CPU % | MEM USAGE / LIMIT | MEM % | NET I/O | BLOCK I/O | PIDS"}
+	for _, name := range names {
+		s := latest[name]
+		target := s.Target
+		if s.Job != "" {
+			target += " " + s.Job
+		}
+		output = append(output, fmt.Sprintf("%s|%.2f%%|%s / %s|%.2f%%|%s / %s|%s / %s|%d", target, s.CPUPercent,
+			byteSize(s.MemoryUsage), byteSize(s.MemoryLimit), s.MemoryPercent,
+			byteSize(s.NetRx), byteSize(s.NetTx), byteSize(s.BlockRead), byteSize(s.BlockWrite), s.Pids))
+	}
+	table := columnize.SimpleFormat(output)
+	if len(ooms) > 0 {
+		oomOutput := []string{"OOM KILLED | TARGET | CONTAINER"}
+		for i, ev := range ooms {
+			if i == 5 {
+				break
+			}
+			target := ev.Target
+			if ev.Job != "" {
+				target += " " + ev.Job
+			}
+			oomOutput = append(oomOutput, ev.Time+"|"+target+"|"+ev.Container)
+		}
+		table += "\n\n" + columnize.SimpleFormat(oomOutput)
+	}
+	return table
+}
+
+// byteSize formats bytes with a binary unit like 1.5GiB
+func byteSize(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	json "github.com/json-iterator/go"
+	"github.com/perlogix/dama/data"
+	"github.com/ryanuber/columnize"
+)
+
+// webhookCmd handles the webhook add, ls, rm, test and log subcommands for notifications of your events
+func webhookCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("webhook add", flag.ExitOnError)
+		events := fs.String("events", "", "Comma separated events, every event when empty")
+		secret := fs.String("secret", "", "Secret to sign payloads with, generated when empty")
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			return errors.New("Usage: dama webhook add [-events deploy.healthy,job.finished] [-secret s] <name> <url>")
+		}
+		hook := data.Webhook{Name: fs.Arg(0), URL: fs.Arg(1), Secret: *secret}
+		if *events != "" {
+			hook.Events = strings.Split(*events, ",")
+		}
+		var created data.Webhook
+		err := postWebhook("webhooks", hook, 201, &created)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created webhook " + created.Name + ", payloads are signed in X-Dama-Signature with this secret\n" + created.Secret)
+	case "ls":
+		var hooks []data.Webhook
+		err := getJSON("webhooks", &hooks)
+		if err != nil {
+			return err
+		}
+		output := []string{"NAME | URL | EVENTS | CREATED"}
+		for _, h := range hooks {
+			events := strings.Join(h.Events, ",")
+			if events == "" {
+				events = "all"
+			}
+			output = append(output, h.Name+"|"+h.URL+"|"+events+"|"+h.Created)
+		}
+		fmt.Println(columnize.SimpleFormat(output))
+	case "rm":
+		if len(args) != 2 {
+			return errors.New("Usage: dama webhook rm <name>")
+		}
+		req, err := http.NewRequest("DELETE", server+"webhooks/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(username, key)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			return errors.New(string(body))
+		}
+		fmt.Println("Deleted webhook " + args[1])
+	case "test":
+		if len(args) != 2 {
+			return errors.New("Usage: dama webhook test <name>")
+		}
+		var d data.Delivery
+		err := postWebhook("webhooks/"+url.PathEscape(args[1])+"/test", nil, 200, &d)
+		if err != nil {
+			return err
+		}
+		fmt.Println(deliveriesTable([]data.Delivery{d}))
+	case "log":
+		var ds []data.Delivery
+		err := getJSON("webhooks/deliveries", &ds)
+		if err != nil {
+			return err
+		}
+		fmt.Println(deliveriesTable(ds))
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// deliveriesTable formats webhook deliveries
+func deliveriesTable(ds []data.Delivery) string {
+	output := []string{"TIME | WEBHOOK | EVENT | STATUS | ATTEMPTS | ERROR"}
+	for _, d := range ds {
+		output = append(output, d.Time+"|"+d.Webhook+"|"+d.Event+"|"+strconv.Itoa(d.Status)+"|"+strconv.Itoa(d.Attempts)+"|"+d.Error)
+	}
+	return columnize.SimpleFormat(output)
+}
+
+// postWebhook posts JSON to a webhooks path and reads the response into v
+func postWebhook(path string, in interface{}, status int, v interface{}) error {
+	b := new(bytes.Buffer)
+	if in != nil {
+		err := json.NewEncoder(b).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest("POST", server+path, b)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	req.SetBasicAuth(username, key)
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		return errors.New(string(body))
+	}
+	return json.Unmarshal(body, v)
+}
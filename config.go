@@ -17,6 +17,7 @@ type HTTPS struct {
 	Pem       string `required:"true"`
 	Key       string `required:"true"`
 	VerifyTLS bool   `default:"false"`
+	CertDir   string
 }
 
 // Docker struct for docker primary key, contains docker client configurations
@@ -31,6 +32,61 @@ type Gotty struct {
 	TLS bool `default:"false"`
 }
 
+// Tracking struct for tracking primary key, URL is how containers reach this server for DAMA_TRACKING_URL
+type Tracking struct {
+	URL string `default:"https://172.17.0.1:8443"`
+}
+
+// Routing struct for routing primary key, deployed APIs are served at <key>.<Domain> when Domain is set,
+// Hosts are the host names of the server itself which users can't route to their APIs
+type Routing struct {
+	Domain string
+	Hosts  []string
+}
+
+// Metrics struct for metrics primary key, scraping /metrics needs Token as a bearer token or the admin login
+type Metrics struct {
+	Token string
+}
+
+// Audit struct for audit primary key, Sink is file, stdout or webhook and only the file can be queried at /admin/audit
+type Audit struct {
+	Sink    string `default:"file"`
+	File    string `default:"audit.log"`
+	Webhook string
+}
+
+// Webhook struct for a global webhook in the notify primary key, every event is sent when Events is empty
+type Webhook struct {
+	Name   string
+	URL    string
+	Secret string
+	Events []string
+}
+
+// Notify struct for notify primary key, global Webhooks get the events of every user. A delivery is tried Retries
+// times, sandboxes warn ExpireWarning seconds before they expire and deploys fail when not healthy in HealthTimeout.
+type Notify struct {
+	Webhooks      []Webhook
+	Retries       int `default:"5"`
+	ExpireWarning int `default:"300"`
+	HealthTimeout int `default:"300"`
+}
+
+// Capture struct for capture primary key, captures of a deployment rotate at MaxSize bytes and MaxFiles are kept
+type Capture struct {
+	MaxSize  int64 `default:"10485760"`
+	MaxFiles int   `default:"5"`
+	MaxBody  int   `default:"65536"`
+}
+
+// Queue struct for queue primary key, contains container admission limits, 0 is unlimited
+type Queue struct {
+	MaxContainers     int `default:"20"`
+	MaxUserContainers int `default:"3"`
+	Timeout           int `default:"600"`
+}
+
 // DamaConfig variable with config.yml configurations
 var DamaConfig = struct {
 	AdminUsername string   `env:"DamaUser" required:"true"`
@@ -38,10 +94,23 @@ var DamaConfig = struct {
 	Images        []string `required:"true"`
 	Expire        string   `default:"1200"`
 	DeployExpire  string   `default:"86400"`
+	JobExpire     string   `default:"86400"`
+	ExecTimeout   int      `default:"570"`
 	UploadSize    int      `default:"2000000000"`
 	EnvSize       int      `default:"20"`
+	Terminal      string   `default:"native"`
+	Recording     string   `default:"output"`
+	RecordingSize int64    `default:"104857600"`
+	RecordingKept int      `default:"20"`
 	Gotty         Gotty
 	Docker        Docker
+	Queue         Queue
+	Tracking      Tracking
+	Routing       Routing
+	Capture       Capture
+	Metrics       Metrics
+	Audit         Audit
+	Notify        Notify
 	DB            Redis
 	HTTPS         HTTPS
 }{}
@@ -17,6 +17,52 @@ type Git struct {
 	SHA    string `yaml:"sha" json:"sha"`
 }
 
+// Access configuration for access primary key, a private deployment needs a consumer API key and
+// requests from outside of Allow are refused when it's set
+type Access struct {
+	Private bool     `yaml:"private" json:"private"`
+	Allow   []string `yaml:"allow" json:"allow"`
+}
+
+// Limits configuration for limits primary key, rates are requests per second and 0 is unlimited
+type Limits struct {
+	Rate        float64 `yaml:"rate" json:"rate"`
+	Burst       int     `yaml:"burst" json:"burst"`
+	KeyRate     float64 `yaml:"key_rate" json:"key_rate"`
+	KeyBurst    int     `yaml:"key_burst" json:"key_burst"`
+	MaxBody     int64   `yaml:"max_body" json:"max_body"`
+	Concurrency int     `yaml:"concurrency" json:"concurrency"`
+}
+
+// Capture configuration for capture primary key, Sample is the share of API requests captured from 0 to 1
+// and the values of Redact keys in JSON bodies and query params are replaced
+type Capture struct {
+	Sample float64  `yaml:"sample" json:"sample"`
+	Redact []string `yaml:"redact" json:"redact"`
+}
+
+// Condition for the when key of a step, a step without a condition runs when all of it's needs succeeded
+type Condition struct {
+	Step     string  `yaml:"step" json:"step"`
+	ExitCode *int    `yaml:"exit_code" json:"exit_code"`
+	File     string  `yaml:"file" json:"file"`
+	Key      string  `yaml:"key" json:"key"`
+	Op       string  `yaml:"op" json:"op"`
+	Value    float64 `yaml:"value" json:"value"`
+}
+
+// Step configuration for a pipeline step in the steps primary key
+type Step struct {
+	Name   string     `yaml:"name" json:"name"`
+	Image  string     `yaml:"image" json:"image"`
+	Pip    string     `yaml:"pip" json:"pip"`
+	Cmd    string     `yaml:"cmd" json:"cmd"`
+	Python string     `yaml:"python" json:"python"`
+	Needs  []string   `yaml:"needs" json:"needs"`
+	When   *Condition `yaml:"when" json:"when"`
+	Deploy bool       `yaml:"deploy" json:"deploy"`
+}
+
 // Damafile struct for both server JSON & client YML
 type Damafile struct {
 	Project    string   `yaml:"project" json:"project"`
@@ -29,6 +75,281 @@ type Damafile struct {
 	Pip        string   `yaml:"pip" json:"pip"`
 	Image      string   `yaml:"image" json:"image"`
 	Port       string   `yaml:"port" json:"port"`
+	Model      string   `yaml:"model" json:"model"`
+	Schedule   string   `yaml:"schedule" json:"schedule"`
+	Redeploy   bool     `yaml:"redeploy" json:"redeploy"`
+	Steps      []Step   `yaml:"steps" json:"steps"`
+	Services   []string `yaml:"services" json:"services"`
+	Access     Access   `yaml:"access" json:"access"`
+	Limits     Limits   `yaml:"limits" json:"limits"`
+	Capture    Capture  `yaml:"capture" json:"capture"`
 	Git        Git
 	AWSs3      AWSs3
 }
+
+// Usage struct for workspace quota details returned by the server
+type Usage struct {
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+	Limit     int64 `json:"limit"`
+}
+
+// Artifact struct for a registered model or file stored in the artifact registry
+type Artifact struct {
+	Name    string                 `yaml:"name" json:"name"`
+	Version int64                  `yaml:"version" json:"version"`
+	File    string                 `yaml:"file" json:"file"`
+	Digest  string                 `yaml:"digest" json:"digest"`
+	Size    int64                  `yaml:"size" json:"size"`
+	Metrics map[string]interface{} `yaml:"metrics" json:"metrics"`
+	GitSHA  string                 `yaml:"git_sha" json:"git_sha"`
+	RunID   string                 `yaml:"run_id" json:"run_id"`
+	Created string                 `yaml:"created" json:"created"`
+}
+
+// Job struct for batch job records returned by the server
+type Job struct {
+	ID        string  `yaml:"id" json:"id"`
+	Project   string  `yaml:"project" json:"project"`
+	Image     string  `yaml:"image" json:"image"`
+	Container string  `yaml:"container" json:"container"`
+	Schedule  string  `yaml:"schedule" json:"schedule"`
+	Pipeline  string  `yaml:"pipeline" json:"pipeline"`
+	Step      string  `yaml:"step" json:"step"`
+	Status    string  `yaml:"status" json:"status"`
+	ExitCode  int     `yaml:"exit_code" json:"exit_code"`
+	Submitted string  `yaml:"submitted" json:"submitted"`
+	Started   string  `yaml:"started" json:"started"`
+	Finished  string  `yaml:"finished" json:"finished"`
+	Duration  float64 `yaml:"duration" json:"duration"`
+	Error     string  `yaml:"error" json:"error"`
+}
+
+// Schedule struct for a Damafile ran as a batch job on a cron schedule
+type Schedule struct {
+	Name     string   `yaml:"name" json:"name"`
+	Damafile Damafile `yaml:"damafile" json:"damafile"`
+	Created  string   `yaml:"created" json:"created"`
+	LastRun  string   `yaml:"last_run" json:"last_run"`
+	LastJob  string   `yaml:"last_job" json:"last_job"`
+	Next     string   `yaml:"next" json:"next"`
+	Runs     []Job    `yaml:"runs" json:"runs,omitempty"`
+}
+
+// QueueEntry struct for a request waiting in the queue to start a container
+type QueueEntry struct {
+	ID       string `yaml:"id" json:"id"`
+	User     string `yaml:"user" json:"user"`
+	Kind     string `yaml:"kind" json:"kind"`
+	Position int    `yaml:"position" json:"position"`
+	Enqueued string `yaml:"enqueued" json:"enqueued"`
+}
+
+// Queue struct for the admission queue details returned to admins
+type Queue struct {
+	Running           int          `yaml:"running" json:"running"`
+	Starting          int          `yaml:"starting" json:"starting"`
+	MaxContainers     int          `yaml:"max_containers" json:"max_containers"`
+	MaxUserContainers int          `yaml:"max_user_containers" json:"max_user_containers"`
+	Waiting           []QueueEntry `yaml:"waiting" json:"waiting"`
+}
+
+// StepStatus struct for the state of a single step in a pipeline
+type StepStatus struct {
+	Name     string `yaml:"name" json:"name"`
+	Status   string `yaml:"status" json:"status"`
+	Job      string `yaml:"job" json:"job"`
+	ExitCode int    `yaml:"exit_code" json:"exit_code"`
+	Reason   string `yaml:"reason" json:"reason"`
+}
+
+// Pipeline struct for a multi-step run of a Damafile returned by the server
+type Pipeline struct {
+	ID        string       `yaml:"id" json:"id"`
+	Project   string       `yaml:"project" json:"project"`
+	Status    string       `yaml:"status" json:"status"`
+	Submitted string       `yaml:"submitted" json:"submitted"`
+	Finished  string       `yaml:"finished" json:"finished"`
+	Steps     []StepStatus `yaml:"steps" json:"steps"`
+	Damafile  Damafile     `yaml:"damafile" json:"damafile"`
+}
+
+// Tracking struct for params and metrics posted to DAMA_TRACKING_URL or written to metrics.json,
+// a metric value is a number or a list of numbers
+type Tracking struct {
+	Params  map[string]interface{} `yaml:"params" json:"params"`
+	Metrics map[string]interface{} `yaml:"metrics" json:"metrics"`
+	Step    *int                   `yaml:"step" json:"step"`
+}
+
+// MetricPoint struct for a single value of a time-series metric
+type MetricPoint struct {
+	Step  int     `yaml:"step" json:"step"`
+	Value float64 `yaml:"value" json:"value"`
+	Time  string  `yaml:"time" json:"time"`
+}
+
+// Run struct for the params and metrics of an experiment run, identified by PROJECT and TIMESTAMP
+type Run struct {
+	Project   string                   `yaml:"project" json:"project"`
+	Timestamp string                   `yaml:"timestamp" json:"timestamp"`
+	RunID     string                   `yaml:"run_id" json:"run_id"`
+	Created   string                   `yaml:"created" json:"created"`
+	Params    map[string]interface{}   `yaml:"params" json:"params"`
+	Metrics   map[string]float64       `yaml:"metrics" json:"metrics"`
+	Series    map[string][]MetricPoint `yaml:"series" json:"series,omitempty"`
+}
+
+// Experiment struct for a project with tracked runs
+type Experiment struct {
+	Project string `yaml:"project" json:"project"`
+	Runs    int64  `yaml:"runs" json:"runs"`
+}
+
+// Exec struct for a command to run in the sandbox container, Timeout is in seconds
+type Exec struct {
+	Cmd     []string `yaml:"cmd" json:"cmd"`
+	Stdin   string   `yaml:"stdin" json:"stdin"`
+	Timeout int      `yaml:"timeout" json:"timeout"`
+}
+
+// ExecFrame struct for a line of streamed exec output, the last frame has the exit code
+type ExecFrame struct {
+	Stream   string `yaml:"stream" json:"stream,omitempty"`
+	Data     string `yaml:"data" json:"data,omitempty"`
+	ExitCode *int   `yaml:"exit_code" json:"exit_code,omitempty"`
+	Error    string `yaml:"error" json:"error,omitempty"`
+}
+
+// Share struct for access another user has to a sandbox terminal session
+type Share struct {
+	User     string `yaml:"user" json:"user"`
+	ReadOnly bool   `yaml:"readonly" json:"readonly"`
+	Created  string `yaml:"created" json:"created"`
+}
+
+// InputEvent struct for input typed into a shared terminal session
+type InputEvent struct {
+	Time  string `yaml:"time" json:"time"`
+	User  string `yaml:"user" json:"user"`
+	Input string `yaml:"input" json:"input"`
+}
+
+// Recording struct for an asciicast v2 recording of a terminal session
+type Recording struct {
+	ID       string  `yaml:"id" json:"id"`
+	User     string  `yaml:"user" json:"user"`
+	Input    bool    `yaml:"input" json:"input"`
+	Started  string  `yaml:"started" json:"started"`
+	Finished string  `yaml:"finished" json:"finished"`
+	Duration float64 `yaml:"duration" json:"duration"`
+	Size     int64   `yaml:"size" json:"size"`
+	Trimmed  bool    `yaml:"trimmed" json:"trimmed"`
+}
+
+// Domain struct for a host name routed to a users deployed API
+type Domain struct {
+	Host   string `yaml:"host" json:"host"`
+	Custom bool   `yaml:"custom" json:"custom"`
+}
+
+// APIKey struct for a consumer API key of a deployment, Key is only returned when it's created
+type APIKey struct {
+	Name    string `yaml:"name" json:"name"`
+	Key     string `yaml:"key" json:"key,omitempty"`
+	Prefix  string `yaml:"prefix" json:"prefix"`
+	Created string `yaml:"created" json:"created"`
+}
+
+// CapturedRequest struct for a sampled request and response of a deployed API
+type CapturedRequest struct {
+	Time     string      `yaml:"time" json:"time"`
+	Method   string      `yaml:"method" json:"method"`
+	Path     string      `yaml:"path" json:"path"`
+	Query    string      `yaml:"query" json:"query,omitempty"`
+	Consumer string      `yaml:"consumer" json:"consumer,omitempty"`
+	Status   int         `yaml:"status" json:"status"`
+	Duration float64     `yaml:"duration" json:"duration"`
+	Request  interface{} `yaml:"request" json:"request,omitempty"`
+	Response interface{} `yaml:"response" json:"response,omitempty"`
+}
+
+// ContainerStats struct for a resource usage sample of a users container, memory is in bytes against the memory limit
+type ContainerStats struct {
+	Target        string  `yaml:"target" json:"target"`
+	Job           string  `yaml:"job" json:"job,omitempty"`
+	Time          string  `yaml:"time" json:"time"`
+	CPUPercent    float64 `yaml:"cpu_percent" json:"cpu_percent"`
+	MemoryUsage   uint64  `yaml:"memory_usage" json:"memory_usage"`
+	MemoryLimit   uint64  `yaml:"memory_limit" json:"memory_limit"`
+	MemoryPercent float64 `yaml:"memory_percent" json:"memory_percent"`
+	NetRx         uint64  `yaml:"net_rx" json:"net_rx"`
+	NetTx         uint64  `yaml:"net_tx" json:"net_tx"`
+	BlockRead     uint64  `yaml:"block_read" json:"block_read"`
+	BlockWrite    uint64  `yaml:"block_write" json:"block_write"`
+	Pids          uint64  `yaml:"pids" json:"pids"`
+}
+
+// OOMEvent struct for a users container killed for running out of memory
+type OOMEvent struct {
+	Time      string `yaml:"time" json:"time"`
+	Target    string `yaml:"target" json:"target"`
+	Job       string `yaml:"job" json:"job,omitempty"`
+	Container string `yaml:"container" json:"container"`
+}
+
+// AuditEvent struct for an action a user took on the server, Result is success or failure by the status code
+type AuditEvent struct {
+	Time      string            `yaml:"time" json:"time"`
+	Actor     string            `yaml:"actor" json:"actor"`
+	Action    string            `yaml:"action" json:"action"`
+	Target    string            `yaml:"target" json:"target,omitempty"`
+	Result    string            `yaml:"result" json:"result"`
+	Status    int               `yaml:"status" json:"status"`
+	IP        string            `yaml:"ip" json:"ip"`
+	RequestID string            `yaml:"request_id" json:"request_id"`
+	Details   map[string]string `yaml:"details" json:"details,omitempty"`
+}
+
+// Webhook struct for an outgoing webhook, every event is sent when Events is empty and Secret is only returned when it's created
+type Webhook struct {
+	Name    string   `yaml:"name" json:"name"`
+	URL     string   `yaml:"url" json:"url"`
+	Secret  string   `yaml:"secret" json:"secret,omitempty"`
+	Events  []string `yaml:"events" json:"events"`
+	Created string   `yaml:"created" json:"created"`
+}
+
+// Notification struct for the JSON payload posted to webhooks, Slack shows Text
+type Notification struct {
+	ID    string      `yaml:"id" json:"id"`
+	Event string      `yaml:"event" json:"event"`
+	User  string      `yaml:"user" json:"user"`
+	Time  string      `yaml:"time" json:"time"`
+	Text  string      `yaml:"text" json:"text"`
+	Data  interface{} `yaml:"data" json:"data,omitempty"`
+}
+
+// S3Transfer struct for a pull or push of a users workspace, Objects is how many were transferred
+type S3Transfer struct {
+	Direction string  `yaml:"direction" json:"direction"`
+	Bucket    string  `yaml:"bucket" json:"bucket"`
+	Status    string  `yaml:"status" json:"status"`
+	Objects   int     `yaml:"objects" json:"objects"`
+	Error     string  `yaml:"error" json:"error,omitempty"`
+	Time      string  `yaml:"time" json:"time"`
+	Duration  float64 `yaml:"duration" json:"duration"`
+}
+
+// Delivery struct for the result of posting a notification to a webhook, Status is the last response status
+type Delivery struct {
+	ID       string  `yaml:"id" json:"id"`
+	Webhook  string  `yaml:"webhook" json:"webhook"`
+	Event    string  `yaml:"event" json:"event"`
+	URL      string  `yaml:"url" json:"url"`
+	Status   int     `yaml:"status" json:"status"`
+	Attempts int     `yaml:"attempts" json:"attempts"`
+	Error    string  `yaml:"error" json:"error,omitempty"`
+	Time     string  `yaml:"time" json:"time"`
+	Duration float64 `yaml:"duration" json:"duration"`
+}
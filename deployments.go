@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// apiOwner returns the user a deployed API key belongs to, sandbox API keys have no owner
+func apiOwner(key string) string {
+	owner, _ := db.HGet("apiOwner", key).Result()
+	return owner
+}
+
+// validAccess checks every allowlist entry is an IP or CIDR
+func validAccess(a data.Access) error {
+	for _, allow := range a.Allow {
+		if _, _, err := net.ParseCIDR(allow); err == nil {
+			continue
+		}
+		if net.ParseIP(allow) == nil {
+			return errors.New("Invalid IP or CIDR in access allow: " + allow)
+		}
+	}
+	return nil
+}
+
+// setAccess keeps the access policy of the users deployment
+func setAccess(name string, a data.Access) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return db.HSet(name, "access", b).Err()
+}
+
+// getAccess returns the access policy of the users deployment, a deployment without one is public
+func getAccess(name string) data.Access {
+	a := data.Access{}
+	if raw, _ := db.HGet(name, "access").Result(); raw != "" {
+		json.Unmarshal([]byte(raw), &a)
+	}
+	return a
+}
+
+// allowedIP reports if an IP is in the allowlist, an empty allowlist allows every IP
+func allowedIP(allow []string, ip net.IP) bool {
+	if len(allow) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, a := range allow {
+		if _, cidr, err := net.ParseCIDR(a); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(a); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashKey returns the digest API keys are stored as
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// consumerKey returns the API key of a request from the X-API-Key header or a bearer token and the header it's in
+func consumerKey(c *gin.Context) (string, string) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key, "X-API-Key"
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), "Authorization"
+	}
+	return "", ""
+}
+
+// checkAccess enforces the access policy of the owners deployment on an API request, the name of the consumers
+// API key is returned and an empty name means there's no key. The client IP is the address of the connection.
+// Keys are only checked when the deployment is private or has keys, otherwise the headers are the APIs own.
+func checkAccess(c *gin.Context, owner string) (string, bool) {
+	access := getAccess(owner)
+	host, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
+	if !allowedIP(access.Allow, net.ParseIP(host)) {
+		c.String(403, "Forbidden")
+		return "", false
+	}
+	var consumer string
+	keys, _ := db.HLen(owner + "_api_key_hashes").Result()
+	if key, header := consumerKey(c); key != "" && (access.Private || keys > 0) {
+		consumer, _ = db.HGet(owner+"_api_key_hashes", hashKey(key)).Result()
+		if consumer == "" {
+			c.String(401, "Invalid API key")
+			return "", false
+		}
+		// The key isn't passed on to the container
+		c.Request.Header.Del(header)
+	}
+	if access.Private && consumer == "" {
+		c.Header("WWW-Authenticate", "Bearer")
+		c.String(401, "API key required")
+		return "", false
+	}
+	c.Request.Header.Set("X-Dama-Consumer", consumer)
+	return consumer, true
+}
+
+// serveAPI proxies a request to the API with a key after the access policy and limits of it's deployment are checked,
+// sampled requests to a deployment are captured
+func serveAPI(c *gin.Context, key, path string) {
+	backend := apiBackend(key)
+	if backend == "" {
+		c.String(400, "API not found")
+		return
+	}
+	if owner := apiOwner(key); owner != "" {
+		defer observeAPI(c, owner)()
+		consumer, ok := checkAccess(c, owner)
+		if !ok {
+			return
+		}
+		release, ok := limitRequest(c, owner, consumer)
+		if !ok {
+			return
+		}
+		defer release()
+		if cp := getCapture(owner); sampled(c, cp) {
+			defer startCapture(c, owner, consumer, cp)()
+		}
+	}
+	proxyTo(c, backend, path)
+}
+
+// deploymentOwner returns the user of the name param, which is a user or their deployed API key,
+// only the user and the admin get through
+func deploymentOwner(c *gin.Context) (string, bool) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	owner := c.Param("name")
+	if o := apiOwner(owner); o != "" {
+		owner = o
+	}
+	if owner != name && !isAdmin(name) {
+		c.String(403, "Forbidden")
+		return "", false
+	}
+	if exist, _ := db.HGet("accounts", owner).Result(); exist == "" {
+		c.String(404, "Deployment not found")
+		return "", false
+	}
+	return owner, true
+}
+
+// createAPIKey route creates a consumer API key for a deployment, the key is only returned once
+func createAPIKey(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	apiKey := &data.APIKey{}
+	if err := c.Bind(apiKey); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !validName.MatchString(apiKey.Name) {
+		c.String(400, "Key name needs to be letters, numbers, dots, dashes or underscores")
+		return
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	key := hex.EncodeToString(b)
+	record := data.APIKey{Name: apiKey.Name, Prefix: key[:8], Created: time.Now().UTC().Format(time.RFC3339)}
+	raw, err := json.Marshal(record)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	added, err := db.HSetNX(owner+"_api_keys", record.Name, raw).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !added {
+		c.String(409, "API key "+record.Name+" already exists")
+		return
+	}
+	db.HSet(owner+"_api_key_hashes", hashKey(key), record.Name)
+	record.Key = key
+	c.JSON(201, record)
+}
+
+// listAPIKeys route returns the consumer API keys of a deployment without the keys
+func listAPIKeys(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	all, err := db.HGetAll(owner + "_api_keys").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	keys := []data.APIKey{}
+	for _, raw := range all {
+		var k data.APIKey
+		if err := json.Unmarshal([]byte(raw), &k); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	c.JSON(200, keys)
+}
+
+// deleteAPIKey route revokes a consumer API key of a deployment
+func deleteAPIKey(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	keyName := c.Param("key")
+	n, err := db.HDel(owner+"_api_keys", keyName).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if n == 0 {
+		c.String(404, "API key not found")
+		return
+	}
+	hashes, _ := db.HGetAll(owner + "_api_key_hashes").Result()
+	for hash, name := range hashes {
+		if name == keyName {
+			db.HDel(owner+"_api_key_hashes", hash)
+		}
+	}
+	c.String(200, "Deleted")
+}
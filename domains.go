@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+var validHost = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// apiBackend returns the address of the deployed or sandbox API with a key
+func apiBackend(key string) string {
+	if dpAPI, _ := db.HGet("deployedPort", key).Result(); dpAPI != "" {
+		return "localhost:" + dpAPI
+	}
+	if sbAPI, _ := db.HGet("sandboxPort", key).Result(); sbAPI != "" {
+		return "localhost:" + sbAPI
+	}
+	return ""
+}
+
+// requestHost returns the lower case host of a request without it's port
+func requestHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// reservedHost reports if a host belongs to the server, it's own names and every name under Routing.Domain
+func reservedHost(host string) bool {
+	domain := strings.ToLower(DamaConfig.Routing.Domain)
+	if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+		return true
+	}
+	for _, h := range DamaConfig.Routing.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	cert := serverCertificate()
+	return cert != nil && cert.VerifyHostname(host) == nil
+}
+
+// hostAPI returns the API key a host routes to, custom is true when the host was added by a user.
+// Hosts of the server are never routed to a custom host.
+func hostAPI(host string) (key string, custom bool) {
+	domain := strings.ToLower(DamaConfig.Routing.Domain)
+	if domain != "" && strings.HasSuffix(host, "."+domain) {
+		label := strings.TrimSuffix(host, "."+domain)
+		if !strings.Contains(label, ".") {
+			return label, false
+		}
+	}
+	if reservedHost(host) {
+		return "", false
+	}
+	if user, _ := db.HGet("hosts", host).Result(); user != "" {
+		deployed, _ := db.HGet(user, "deployed").Result()
+		return deployed, true
+	}
+	return "", false
+}
+
+// hostRouter middleware proxies requests for a deployments host to it's API with the full path after it's access policy is checked,
+// any other host is left to dama's own routes
+func hostRouter(c *gin.Context) {
+	key, custom := hostAPI(requestHost(c.Request.Host))
+	if key == "" {
+		return
+	}
+	if apiBackend(key) == "" {
+		if custom {
+			c.String(404, "API not found")
+			c.Abort()
+		}
+		return
+	}
+	serveAPI(c, key, c.Request.URL.Path)
+	c.Abort()
+}
+
+// addDomain route routes a host name to the users deployed API
+func addDomain(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	domain := &data.Domain{}
+	if err := c.Bind(domain); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	host := strings.ToLower(domain.Host)
+	if !validHost.MatchString(host) {
+		c.String(400, "Invalid host name")
+		return
+	}
+	if reservedHost(host) {
+		c.String(403, "Host name belongs to the server")
+		return
+	}
+	added, err := db.HSetNX("hosts", host, name).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !added {
+		if owner, _ := db.HGet("hosts", host).Result(); owner != name {
+			c.String(409, "Host name already taken")
+			return
+		}
+	}
+	db.SAdd(name+"_hosts", host)
+	c.JSON(201, data.Domain{Host: host, Custom: true})
+}
+
+// listDomains route returns the host names routed to the users deployed API
+func listDomains(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	hosts, err := db.SMembers(name + "_hosts").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	sort.Strings(hosts)
+	domains := []data.Domain{}
+	if DamaConfig.Routing.Domain != "" {
+		if deployed, _ := db.HGet(name, "deployed").Result(); deployed != "" {
+			domains = append(domains, data.Domain{Host: deployed + "." + strings.ToLower(DamaConfig.Routing.Domain)})
+		}
+	}
+	for _, h := range hosts {
+		domains = append(domains, data.Domain{Host: h, Custom: true})
+	}
+	c.JSON(200, domains)
+}
+
+// removeDomain route stops routing a host name to the users deployed API
+func removeDomain(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	host := strings.ToLower(c.Param("host"))
+	if owner, _ := db.HGet("hosts", host).Result(); owner != name {
+		c.String(404, "Host name not found")
+		return
+	}
+	db.HDel("hosts", host)
+	db.SRem(name+"_hosts", host)
+	c.String(200, "Removed")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// execTimedOut is the exit code returned when a command runs longer than it's timeout, like timeout(1)
+const execTimedOut = 124
+
+// execKilled is the exit code of a command killed by timeout -s KILL
+const execKilled = 128 + 9
+
+// execGrace is the time after the timeout to wait for a killed command to exit and for it's exit code to be written,
+// the longest timeout leaves it before the servers write timeout
+const execGrace = 30 * time.Second
+
+// execOutput is shared by the stdout and stderr writers of an exec, done stops writes once the response is finished
+type execOutput struct {
+	mu   sync.Mutex
+	c    *gin.Context
+	done bool
+}
+
+// execWriter writes output of a stream as JSON lines and flushes them to the client
+type execWriter struct {
+	stream string
+	out    *execOutput
+}
+
+func (e *execWriter) Write(p []byte) (int, error) {
+	e.out.mu.Lock()
+	defer e.out.mu.Unlock()
+	if e.out.done {
+		return 0, io.ErrClosedPipe
+	}
+	err := writeFrame(e.out.c, data.ExecFrame{Stream: e.stream, Data: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame writes a single exec frame as a JSON line
+func writeFrame(c *gin.Context, frame data.ExecFrame) error {
+	b, err := json.Marshal(frame)
+	if err != nil {
+		return err
+	}
+	_, err = c.Writer.Write(append(b, '\n'))
+	c.Writer.Flush()
+	return err
+}
+
+// execCmd route runs a command in the users sandbox container with docker exec,
+// stdout and stderr are streamed as JSON lines with the exit code in the last line
+func execCmd(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	ex := &data.Exec{}
+	if err := c.Bind(ex); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if len(ex.Cmd) == 0 {
+		c.String(400, "No command specified")
+		return
+	}
+	timeout := ex.Timeout
+	if timeout <= 0 || timeout > DamaConfig.ExecTimeout {
+		timeout = DamaConfig.ExecTimeout
+	}
+	if max := int((writeTimeout - execGrace) / time.Second); timeout > max {
+		timeout = max
+	}
+	ctr, err := userContainer(name, "build")
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	if ctr.State != "running" {
+		c.String(409, "Sandbox container is not running")
+		return
+	}
+	run, _ := db.HGet(name, "run").Result()
+	// timeout kills the command in the container, closing the streams alone leaves it running
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    ctr.ID,
+		Cmd:          append([]string{"timeout", "-s", "KILL", strconv.Itoa(timeout)}, ex.Cmd...),
+		Env:          containerEnv(name, run),
+		WorkingDir:   "/root/workspace",
+		AttachStdin:  ex.Stdin != "",
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(timeout)*time.Second+execGrace)
+	defer cancel()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(200)
+	out := &execOutput{c: c}
+	opts := docker.StartExecOptions{
+		OutputStream: &execWriter{stream: "stdout", out: out},
+		ErrorStream:  &execWriter{stream: "stderr", out: out},
+	}
+	if ex.Stdin != "" {
+		opts.InputStream = strings.NewReader(ex.Stdin)
+	}
+	cw, err := client.StartExecNonBlocking(exec.ID, opts)
+	if err == nil {
+		// Closing the attached streams stops waiting on a command that doesn't exit or a client that went away
+		go func() {
+			<-ctx.Done()
+			cw.Close()
+		}()
+		err = cw.Wait()
+	}
+
+	frame := data.ExecFrame{}
+	code := -1
+	if ctx.Err() == context.DeadlineExceeded {
+		code = execTimedOut
+		frame.Error = "Command timed out"
+	} else if err != nil {
+		frame.Error = err.Error()
+	} else if insp, err := client.InspectExec(exec.ID); err != nil {
+		frame.Error = err.Error()
+	} else if insp.ExitCode == execKilled && time.Since(start) >= time.Duration(timeout)*time.Second {
+		code = execTimedOut
+		frame.Error = "Command timed out"
+	} else {
+		code = insp.ExitCode
+	}
+	frame.ExitCode = &code
+	out.mu.Lock()
+	writeFrame(c, frame)
+	out.done = true
+	out.mu.Unlock()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// sandboxAddr returns the address of a port inside the users running sandbox container, reachable from the server
+func sandboxAddr(name string, port int) (string, error) {
+	ctr, err := userContainer(name, "build")
+	if err != nil {
+		return "", err
+	}
+	if ctr.State != "running" {
+		return "", errors.New("Sandbox container is not running")
+	}
+	insp, err := client.InspectContainer(ctr.ID)
+	if err != nil {
+		return "", err
+	}
+	ip := insp.NetworkSettings.IPAddress
+	if ip == "" {
+		for _, n := range insp.NetworkSettings.Networks {
+			if n.IPAddress != "" {
+				ip = n.IPAddress
+				break
+			}
+		}
+	}
+	if ip == "" {
+		return "", errors.New("Sandbox container has no IP address")
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+}
+
+// forward route tunnels TCP over a websocket to a port inside the users sandbox, no host ports are published for it
+func forward(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	port, err := strconv.Atoi(c.Query("port"))
+	if err != nil || port < 1 || port > 65535 {
+		c.String(400, "port needs to be between 1 and 65535")
+		return
+	}
+	addr, err := sandboxAddr(name, port)
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		c.String(502, "Nothing listening on sandbox port "+strconv.Itoa(port))
+		return
+	}
+	defer conn.Close()
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	go func() {
+		defer conn.Close()
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if werr := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
@@ -8,12 +8,16 @@ require (
 	github.com/gin-contrib/zap v0.0.1
 	github.com/gin-gonic/gin v1.7.1
 	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/gorilla/websocket v1.4.2
 	github.com/jinzhu/configor v1.2.1
 	github.com/json-iterator/go v1.1.12
 	github.com/leekchan/timeutil v0.0.0-20150802142658-28917288c48d
+	github.com/minio/minio-go/v7 v7.0.14
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/onsi/gomega v1.12.0 // indirect
 	github.com/perlogix/dama/gotty-client v0.0.0-20211122012033-c529397ad377
+	github.com/prometheus/client_golang v1.11.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/ryanuber/columnize v2.1.2+incompatible
 	github.com/satori/go.uuid v1.2.0
 	github.com/yhat/wsutil v0.0.0-20170731153501-1d66fa95c997
@@ -24,3 +28,5 @@ require (
 	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
 	gopkg.in/yaml.v2 v2.4.0
 )
+
+replace github.com/perlogix/dama/gotty-client => ./gotty-client
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"time"
@@ -52,7 +53,7 @@ func GetWebsocketURL(httpURL string) (*url.URL, *http.Header, error) {
 	return target, &header, nil
 }
 
-// Client type
+// Client type speaks the gotty websocket protocol, which is served by gotty or by dama itself
 type Client struct {
 	Dialer          *websocket.Dialer
 	Conn            *websocket.Conn
@@ -63,8 +64,18 @@ type Client struct {
 	SkipTLSVerify   bool
 	UseProxyFromEnv bool
 	Connected       bool
+	// Attach is the user whose shared session is joined instead of your own sandbox
+	Attach        string
+	autoReconnect bool
+	dropped       bool
 }
 
+// reconnectAttempts is how many times a dropped connection is dialed again before giving up
+const reconnectAttempts = 10
+
+// maxReconnectDelay caps the exponential backoff between reconnect attempts
+const maxReconnectDelay = 30 * time.Second
+
 type querySingleType struct {
 	Arguments string `json:"Arguments"`
 }
@@ -93,6 +104,9 @@ func (c *Client) Connect(build, new bool, file, img, username, key, port string)
 	if new {
 		header.Add("New", "true")
 	}
+	if c.Attach != "" {
+		header.Add("Attach", c.Attach)
+	}
 	if build {
 		header.Add("Build", "true")
 	} else {
@@ -124,15 +138,21 @@ func (c *Client) Connect(build, new bool, file, img, username, key, port string)
 		return err
 	}
 
-	go c.pingLoop()
+	go c.pingLoop(conn)
 
 	return nil
 }
 
-func (c *Client) pingLoop() {
+// pingLoop pings the server until the connection is closed
+func (c *Client) pingLoop(conn *websocket.Conn) {
 	for {
 		logrus.Debugf("Sending ping")
-		c.write([]byte("1"))
+		c.WriteMutex.Lock()
+		err := conn.WriteMessage(websocket.TextMessage, []byte("1"))
+		c.WriteMutex.Unlock()
+		if err != nil {
+			return
+		}
 		time.Sleep(15 * time.Second)
 	}
 }
@@ -149,7 +169,7 @@ func (c *Client) ExitLoop() {
 	openPoison(fname, c.poison)
 }
 
-// Loop will look indefinitely for new messages
+// Loop will look indefinitely for new messages, when the server sent autoreconnect a dropped connection is reconnected
 func (c *Client) Loop(build, new bool, file, img, username, key, port string) error {
 	var err error
 	if !c.Connected {
@@ -165,22 +185,55 @@ func (c *Client) Loop(build, new bool, file, img, username, key, port string) er
 		}
 	}
 
-	wg := &sync.WaitGroup{}
+	for {
+		c.dropped = false
+		wg := &sync.WaitGroup{}
 
-	wg.Add(1)
-	go c.termsizeLoop(wg)
+		wg.Add(1)
+		go c.termsizeLoop(wg)
 
-	wg.Add(1)
-	go c.readLoop(wg)
+		wg.Add(1)
+		go c.readLoop(wg)
 
-	wg.Add(1)
-	go c.writeLoop(wg)
+		wg.Add(1)
+		go c.writeLoop(wg)
 
-	/* Wait for all of the above goroutines to finish */
-	wg.Wait()
+		/* Wait for all of the above goroutines to finish */
+		wg.Wait()
 
-	logrus.Debug("Client.Loop() exiting")
-	return nil
+		if !c.autoReconnect || !c.dropped {
+			logrus.Debug("Client.Loop() exiting")
+			return nil
+		}
+		fmt.Fprint(os.Stderr, "\r\nConnection lost, reconnecting...\r\n")
+		err = c.reconnect(username, key, port)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, "Reconnected to sandbox\r\n")
+	}
+}
+
+// reconnect dials the server again with exponential backoff, the sandbox session is resumed so nothing new is created
+func (c *Client) reconnect(username, key, port string) error {
+	c.Close()
+	c.Connected = false
+	c.poison = make(chan bool)
+	delay := time.Second
+	var err error
+	for i := 0; i < reconnectAttempts; i++ {
+		time.Sleep(delay)
+		err = c.Connect(false, false, "", "", username, key, port)
+		if err == nil {
+			return nil
+		}
+		logrus.Debugf("Reconnect failed: %v", err)
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+	return err
 }
 
 type winsize struct {
@@ -227,12 +280,36 @@ func die(fname string, poison chan bool) posionReason {
 	return killed
 }
 
+// getTermSize returns the columns and rows of the local terminal
+var getTermSize = func() (int, int, error) {
+	return terminal.GetSize(int(os.Stdout.Fd()))
+}
+
+// sendTermSize sends the size of the local terminal with gotty's resize message
+func (c *Client) sendTermSize() error {
+	cols, rows, err := getTermSize()
+	if err != nil {
+		return err
+	}
+	size, err := json.Marshal(winsize{Rows: uint16(rows), Columns: uint16(cols)})
+	if err != nil {
+		return err
+	}
+	return c.write(append([]byte("2"), size...))
+}
+
 func (c *Client) termsizeLoop(wg *sync.WaitGroup) posionReason {
 
 	defer wg.Done()
 	fname := "termsizeLoop"
 
 	ch := make(chan os.Signal, 1)
+	notifyResize(ch)
+	defer signal.Stop(ch)
+
+	if err := c.sendTermSize(); err != nil {
+		logrus.Debugf("Failed to send terminal size: %v", err)
+	}
 
 	for {
 		select {
@@ -240,6 +317,9 @@ func (c *Client) termsizeLoop(wg *sync.WaitGroup) posionReason {
 			/* Somebody poisoned the well; die */
 			return die(fname, c.poison)
 		case <-ch:
+			if err := c.sendTermSize(); err != nil {
+				logrus.Debugf("Failed to send terminal size: %v", err)
+			}
 		}
 	}
 }
@@ -317,7 +397,7 @@ func (c *Client) readLoop(wg *sync.WaitGroup) posionReason {
 		Data []byte
 		Err  error
 	}
-	msgChan := make(chan MessageNonBlocking)
+	msgChan := make(chan MessageNonBlocking, 1)
 
 	for {
 		go func() {
@@ -333,8 +413,10 @@ func (c *Client) readLoop(wg *sync.WaitGroup) posionReason {
 			if msg.Err != nil {
 
 				if _, ok := msg.Err.(*websocket.CloseError); !ok {
-					logrus.Warnf("c.Conn.ReadMessage: %v", msg.Err)
+					logrus.Debugf("c.Conn.ReadMessage: %v", msg.Err)
 				}
+				// A normal closure means the remote shell exited, anything else is a dropped connection
+				c.dropped = !websocket.IsCloseError(msg.Err, websocket.CloseNormalClosure)
 				return openPoison(fname, c.poison)
 			}
 			if len(msg.Data) == 0 {
@@ -357,7 +439,8 @@ func (c *Client) readLoop(wg *sync.WaitGroup) posionReason {
 			case '3': // json prefs
 				logrus.Debugf("Unhandled protocol message: json pref: %s", string(msg.Data[1:]))
 			case '4': // autoreconnect
-				logrus.Debugf("Unhandled protocol message: autoreconnect: %s", string(msg.Data))
+				logrus.Debugf("Server supports autoreconnect: %s", string(msg.Data))
+				c.autoReconnect = true
 			default:
 				logrus.Warnf("Unhandled protocol message: %s", string(msg.Data))
 			}
@@ -0,0 +1,275 @@
+//go:build !windows
+// +build !windows
+
+package gottyclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// gottyServer is a local server speaking gotty's protocol that records the messages clients send
+type gottyServer struct {
+	*httptest.Server
+	init     chan []byte
+	headers  chan http.Header
+	messages chan []byte
+	// onInit runs after the init message, returning false drops the connection without a close message
+	onInit func(conn *websocket.Conn) bool
+}
+
+func newGottyServer(t *testing.T) *gottyServer {
+	s := &gottyServer{init: make(chan []byte, 4), headers: make(chan http.Header, 4), messages: make(chan []byte, 16)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		s.headers <- r.Header
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.init <- msg
+		if s.onInit != nil && !s.onInit(conn) {
+			return
+		}
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			// Pings are sent on a timer, the tests only look at the other messages
+			if len(msg) > 0 && msg[0] == '1' {
+				continue
+			}
+			s.messages <- msg
+		}
+	}))
+	return s
+}
+
+// next returns the next message sent by the client
+func (s *gottyServer) next(t *testing.T) []byte {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+// connect returns a client connected to the server
+func connect(t *testing.T, s *gottyServer) *Client {
+	t.Helper()
+	c, err := NewClient(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Connect(false, false, "", "", "user", "key", "5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-s.headers
+	select {
+	case msg := <-s.init:
+		init := querySingleType{}
+		if err := json.Unmarshal(msg, &init); err != nil {
+			t.Fatalf("init message %q: %v", msg, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for init message")
+	}
+	return c
+}
+
+// setTermSize replaces the local terminal size for a test
+func setTermSize(t *testing.T, mu *sync.Mutex, cols, rows *int) {
+	orig := getTermSize
+	getTermSize = func() (int, int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return *cols, *rows, nil
+	}
+	t.Cleanup(func() { getTermSize = orig })
+}
+
+// assertResize checks a message is gotty's resize message with the size
+func assertResize(t *testing.T, msg []byte, cols, rows uint16) {
+	t.Helper()
+	if len(msg) == 0 || msg[0] != '2' {
+		t.Fatalf("expected resize message, got %q", msg)
+	}
+	size := struct {
+		Columns uint16 `json:"columns"`
+		Rows    uint16 `json:"rows"`
+	}{}
+	if err := json.Unmarshal(msg[1:], &size); err != nil {
+		t.Fatalf("resize message %q: %v", msg, err)
+	}
+	if size.Columns != cols || size.Rows != rows {
+		t.Fatalf("expected %dx%d, got %dx%d", cols, rows, size.Columns, size.Rows)
+	}
+}
+
+func TestSendTermSize(t *testing.T) {
+	s := newGottyServer(t)
+	defer s.Close()
+	mu := &sync.Mutex{}
+	cols, rows := 132, 43
+	setTermSize(t, mu, &cols, &rows)
+	c := connect(t, s)
+	defer c.Close()
+
+	if err := c.sendTermSize(); err != nil {
+		t.Fatal(err)
+	}
+	assertResize(t, s.next(t), 132, 43)
+}
+
+func TestTermsizeLoop(t *testing.T) {
+	s := newGottyServer(t)
+	defer s.Close()
+	mu := &sync.Mutex{}
+	cols, rows := 80, 24
+	setTermSize(t, mu, &cols, &rows)
+	c := connect(t, s)
+	defer c.Close()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go c.termsizeLoop(wg)
+	assertResize(t, s.next(t), 80, 24)
+
+	mu.Lock()
+	cols, rows = 200, 60
+	mu.Unlock()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	assertResize(t, s.next(t), 200, 60)
+
+	c.ExitLoop()
+	wg.Wait()
+}
+
+func TestReadLoopDropped(t *testing.T) {
+	s := newGottyServer(t)
+	defer s.Close()
+	s.onInit = func(conn *websocket.Conn) bool {
+		conn.WriteMessage(websocket.TextMessage, []byte("410"))
+		return false
+	}
+	c := connect(t, s)
+	defer c.Close()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.readLoop(wg)
+	if !c.autoReconnect {
+		t.Fatal("expected autoreconnect from message 4")
+	}
+	if !c.dropped {
+		t.Fatal("expected connection without close message to be dropped")
+	}
+}
+
+func TestReadLoopClosed(t *testing.T) {
+	s := newGottyServer(t)
+	defer s.Close()
+	s.onInit = func(conn *websocket.Conn) bool {
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		return false
+	}
+	c := connect(t, s)
+	defer c.Close()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	c.readLoop(wg)
+	if c.dropped {
+		t.Fatal("expected normal closure not to be dropped")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	s := newGottyServer(t)
+	defer s.Close()
+	c, err := NewClient(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Connect(true, true, "build", "python:3.11", "user", "key", "5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := <-s.headers; h.Get("New") != "true" {
+		t.Fatalf("expected first connection to ask for a new sandbox, got %q", h.Get("New"))
+	}
+	<-s.init
+
+	err = c.reconnect("user", "key", "5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	h := <-s.headers
+	if h.Get("New") != "" || h.Get("File") != "" {
+		t.Fatalf("expected reconnect to resume the sandbox, got New %q File %q", h.Get("New"), h.Get("File"))
+	}
+	<-s.init
+}
+
+func TestReplay(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24, "timestamp": 1600000000}
+[0.1, "o", "$ python train.py\r\n"]
+[0.2, "i", "q"]
+[0.3, "r", "120x40"]
+[5.0, "o", "epoch 1 loss 0.42\r\n"]
+`
+	c, err := NewClient("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &strings.Builder{}
+	c.SetOutput(out)
+	start := time.Now()
+	err = c.Replay(strings.NewReader(cast), 10, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "$ python train.py\r\nepoch 1 loss 0.42\r\n"; got != want {
+		t.Fatalf("expected output %q, got %q", want, got)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("expected long pauses to be capped")
+	}
+}
+
+func TestReplayVersion(t *testing.T) {
+	c, err := NewClient("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Replay(strings.NewReader(`{"version": 1, "width": 80, "height": 24, "stdout": []}`), 1, 0)
+	if err == nil {
+		t.Fatal("expected asciicast v1 to be rejected")
+	}
+}
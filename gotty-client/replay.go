@@ -0,0 +1,65 @@
+package gottyclient
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"time"
+
+	json "github.com/json-iterator/go"
+)
+
+// castHeader is the first line of an asciicast v2 recording
+type castHeader struct {
+	Version int `json:"version"`
+	Width   int `json:"width"`
+	Height  int `json:"height"`
+}
+
+// Replay writes the output of an asciicast v2 recording to the output stream with it's original timing,
+// speed changes how fast it's played and pauses longer than maxIdle are shortened when maxIdle isn't 0
+func (c *Client) Replay(r io.Reader, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("Empty recording")
+	}
+	header := castHeader{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return err
+	}
+	if header.Version != 2 {
+		return errors.New("Only asciicast v2 recordings can be replayed")
+	}
+	var last float64
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return err
+		}
+		if len(event) != 3 {
+			continue
+		}
+		at, _ := event[0].(float64)
+		kind, _ := event[1].(string)
+		text, _ := event[2].(string)
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		time.Sleep(delay)
+		last = at
+		if kind == "o" {
+			if _, err := c.Output.Write([]byte(text)); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
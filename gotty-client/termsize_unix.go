@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package gottyclient
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyResize relays SIGWINCH to ch whenever the local terminal is resized
+func notifyResize(ch chan os.Signal) {
+	signal.Notify(ch, syscall.SIGWINCH)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package gottyclient
+
+import "os"
+
+// notifyResize does nothing on windows, which has no SIGWINCH, so only the initial size is sent
+func notifyResize(ch chan os.Signal) {}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
 	uuid "github.com/satori/go.uuid"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -34,14 +38,79 @@ func checkImg(a string) bool {
 // getAccounts is used to load accounts into BasicAuth gin middleware
 func getAccounts() gin.Accounts {
 	accounts, _ := db.HGetAll("accounts").Result()
-	if len(accounts) == 0 {
-		if DamaConfig.AdminUsername != "" && DamaConfig.AdminPassword != "" {
-			accounts = map[string]string{DamaConfig.AdminUsername: DamaConfig.AdminPassword}
-		}
+	if accounts == nil {
+		accounts = make(map[string]string)
+	}
+	// The config admin always logs in with the password from config.yml
+	if DamaConfig.AdminUsername != "" && DamaConfig.AdminPassword != "" {
+		accounts[DamaConfig.AdminUsername] = DamaConfig.AdminPassword
 	}
 	return accounts
 }
 
+// writeScript renders a Damafile with a text/template into an executable script in the users workspace
+func writeScript(file, text string, df *data.Damafile) error {
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	t, err := template.New("tmpl").Parse(text)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0755)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = t.Execute(f, df)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setEnvs stores key=value env settings from a Damafile for the users containers
+func setEnvs(name string, env []string) {
+	if env == nil {
+		return
+	}
+	var envs = make(map[string]interface{})
+	for _, e := range env {
+		split := strings.SplitN(e, "=", 2)
+		if len(split) == 2 {
+			envs[split[0]] = split[1]
+		}
+	}
+	if len(envs) > 0 {
+		db.HMSet(name+"_env", envs)
+	}
+}
+
+// isAdmin checks if an authenticated user is the server admin from config.yml or has the admin role, the admin name
+// only authenticates with the password from config.yml
+func isAdmin(name string) bool {
+	if DamaConfig.AdminUsername != "" && DamaConfig.AdminPassword != "" && name == DamaConfig.AdminUsername {
+		return true
+	}
+	role, _ := db.HGet(name, "role").Result()
+	return role == "admin"
+}
+
+// adminOnly is a gin middleware that only lets the server admin through
+func adminOnly(c *gin.Context) {
+	if !isAdmin(c.MustGet(gin.AuthUserKey).(string)) {
+		c.AbortWithStatus(403)
+		return
+	}
+	c.Next()
+}
+
 // getCmd is used to append dama script to docker cmd slice
 func getCmd(img string) []string {
 	inspectImg, _ := client.InspectImage(img)
@@ -55,13 +124,8 @@ func getCmd(img string) []string {
 	return imgCmd
 }
 
-// createContainer creates container for sandbox or deployed environment
-func createContainer(name, image, file, port string, deploy bool) (string, error) {
-	var cmd []string
-	var binds []string
-	var img string
-	var hostname string
-	labels := make(map[string]string)
+// containerEnv returns the users env settings with the variables dama sets for every container
+func containerEnv(name, run string) []string {
 	var env []string
 	envs, err := db.HGetAll(name + "_env").Result()
 	if err == nil {
@@ -69,8 +133,55 @@ func createContainer(name, image, file, port string, deploy bool) (string, error
 			env = append(env, k+"="+v)
 		}
 	}
-
 	env = append(env, "USER="+name)
+	if run != "" {
+		env = append(env, "RUN_ID="+run)
+	}
+	return env
+}
+
+// mergeEnv returns the base env with the keys in override replaced or added
+func mergeEnv(base, override []string) []string {
+	keys := make(map[string]bool)
+	for _, e := range override {
+		keys[strings.SplitN(e, "=", 2)[0]] = true
+	}
+	var env []string
+	for _, e := range base {
+		if !keys[strings.SplitN(e, "=", 2)[0]] {
+			env = append(env, e)
+		}
+	}
+	return append(env, override...)
+}
+
+// prepareS3 starts pulling from S3 into the workspace and sets the labels used to push when the container is removed
+func prepareS3(name string, s3 data.AWSs3, labels map[string]string) error {
+	if s3.BucketPull != "" {
+		err := startS3Pull(name, s3)
+		if err != nil {
+			return errors.New("S3 pull from " + s3.BucketPull + " failed: " + err.Error())
+		}
+	}
+	if s3.BucketPush != "" {
+		labels["s3.bucket_push"] = s3.BucketPush
+		labels["s3.file"] = s3.File
+		labels["s3.dir"] = s3.Dir
+	}
+	return nil
+}
+
+// createContainer creates container for sandbox or deployed environment
+func createContainer(name, image, file, port string, deploy bool) (string, error) {
+	var cmd []string
+	var entrypoint []string
+	var binds []string
+	var img string
+	var hostname string
+	var err error
+	labels := make(map[string]string)
+	run, _ := db.HGet(name, "run").Result()
+	env := containerEnv(name, run)
 	labels["dama"] = "dama"
 	labels["user"] = name
 	if image == "" {
@@ -91,6 +202,15 @@ func createContainer(name, image, file, port string, deploy bool) (string, error
 		deleteContainers(name, "API")
 		deployedAPI, _ := db.HGet(name, "deployed").Result()
 		hostname = deployedAPI
+		if model, _ := db.HGet(name, "model").Result(); model != "" {
+			art, err := getArtifact(name, model)
+			if err != nil {
+				return "", err
+			}
+			modelPath := "/root/models/" + art.File
+			binds = append(binds, artifactPath(strings.TrimPrefix(art.Digest, "sha256:"))+":"+modelPath+":ro")
+			env = append(env, "MODEL_NAME="+art.Name, "MODEL_VERSION="+strconv.FormatInt(art.Version, 10), "MODEL_PATH="+modelPath)
+		}
 	} else {
 		var expire string
 		expire, err = db.HGet(name, "expire").Result()
@@ -102,20 +222,37 @@ func createContainer(name, image, file, port string, deploy bool) (string, error
 		sandboxAPI, _ := db.HGet(name, "sandbox").Result()
 		hostname = sandboxAPI
 		deleteContainers(name, "build")
-		if file != "" {
+		if DamaConfig.Terminal != "gotty" {
+			entrypoint = sandboxKeepalive
+		} else if file != "" {
 			cmd = getCmd(img)
 		}
 	}
+	// Only gotty sandboxes serve their terminal on 8080
+	gotty := !deploy && DamaConfig.Terminal == "gotty"
+	s3, err := getS3(name)
+	if err != nil {
+		return "", err
+	}
+	err = prepareS3(name, s3, labels)
+	if err != nil {
+		return "", err
+	}
+	env = trackingEnv(name, env, labels)
 	uploadPath := filepath.Clean(pwd + "/upload/" + name)
-	binds = []string{uploadPath + ":/root/workspace:rw"}
+	binds = append(binds, uploadPath+":/root/workspace:rw")
 	var portBindings = map[docker.Port][]docker.PortBinding{}
 	portStr := docker.Port(port + "/tcp")
 	portBindings = map[docker.Port][]docker.PortBinding{
-		"8080/tcp": []docker.PortBinding{docker.PortBinding{HostIP: "0.0.0.0"}},
-		portStr:    []docker.PortBinding{docker.PortBinding{HostIP: "0.0.0.0"}},
+		portStr: []docker.PortBinding{docker.PortBinding{HostIP: "0.0.0.0"}},
+	}
+	exposedPorts := map[docker.Port]struct{}{portStr: {}}
+	if gotty || deploy {
+		portBindings["8080/tcp"] = []docker.PortBinding{docker.PortBinding{HostIP: "0.0.0.0"}}
+		exposedPorts["8080/tcp"] = struct{}{}
 	}
 	hostConfig := &docker.HostConfig{PublishAllPorts: false, PortBindings: portBindings, Privileged: false, Binds: binds}
-	opts := docker.CreateContainerOptions{Config: &docker.Config{CPUShares: DamaConfig.Docker.CPUShares, Memory: DamaConfig.Docker.Memory, Cmd: cmd, Hostname: hostname, Image: img, Labels: labels, Env: env, ExposedPorts: map[docker.Port]struct{}{portStr: {}, "8080/tcp": {}}}, HostConfig: hostConfig}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{CPUShares: DamaConfig.Docker.CPUShares, Memory: DamaConfig.Docker.Memory, Entrypoint: entrypoint, Cmd: cmd, Hostname: hostname, Image: img, Labels: labels, Env: env, ExposedPorts: exposedPorts}, HostConfig: hostConfig}
 	ctr, err := client.CreateContainer(opts)
 	if err != nil {
 		return "", err
@@ -124,12 +261,21 @@ func createContainer(name, image, file, port string, deploy bool) (string, error
 	if err != nil {
 		return "", err
 	}
+	if !deploy {
+		err = startServices(name, ctr.ID)
+		if err != nil {
+			return "", err
+		}
+	}
 	insp, err := client.InspectContainer(ctr.ID)
 	if err != nil {
 		return "", err
 	}
 
-	ws := insp.NetworkSettings.Ports["8080/tcp"][0].HostPort
+	var ws string
+	if bindings := insp.NetworkSettings.Ports["8080/tcp"]; len(bindings) > 0 {
+		ws = bindings[0].HostPort
+	}
 	api := insp.NetworkSettings.Ports[portStr][0].HostPort
 	return ws + ":" + api, nil
 }
@@ -145,7 +291,7 @@ func deleteContainers(user, label string) {
 			if k == "user" && v == user {
 				for k := range ctr.Labels {
 					if k == label {
-						err := client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
+						err := removeContainer(ctr)
 						if err != nil {
 							return
 						}
@@ -156,43 +302,72 @@ func deleteContainers(user, label string) {
 	}
 }
 
+// removeContainer keeps the logs and force removes a container, then starts pushing it's workspace to S3, records it's metrics.json and resets the usage counter
+func removeContainer(ctr docker.APIContainers) error {
+	saveContainerLogs(ctr)
+	err := client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
+	if err != nil {
+		return err
+	}
+	s3PushLabels(ctr.Labels)
+	trackingDone(ctr.Labels)
+	resetUsage(ctr.Labels["user"])
+	return nil
+}
+
 // cleanContainers is ran in background via goroutine to clean up expired containers
 func cleanContainers() {
 	for {
 		ctrs, _ := client.ListContainers(docker.ListContainersOptions{All: true, Filters: map[string][]string{"label": {"dama"}}})
 		for _, ctr := range ctrs {
-			for k, v := range ctr.Labels {
-				if k == "expire" {
-					created, err := client.InspectContainer(ctr.ID)
-					if err == nil {
-						delta := time.Since(created.Created)
-						expireInt, _ := strconv.Atoi(v)
-						if int(delta.Seconds()) > expireInt {
-							for k := range ctr.Labels {
-								if k == "build" {
-									db.HDel("wsPort", ctr.Labels["user"])
-								}
-							}
-							err := client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
-							if err != nil {
-								return
-							}
-						}
-					}
+			_, job := ctr.Labels["job"]
+			status := path.Base(strings.Split(ctr.Status, " ")[0])
+			if status == "Exited" {
+				// Exited batch jobs are recorded and removed by waitJob
+				if !job {
+					cleanup(ctr, "exited")
 				}
-				status := path.Base(strings.Split(ctr.Status, " ")[0])
-				if status == "Exited" {
-					err := client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
-					if err != nil {
-						return
-					}
+				continue
+			}
+			v, ok := ctr.Labels["expire"]
+			if !ok {
+				continue
+			}
+			created, err := client.InspectContainer(ctr.ID)
+			if err != nil {
+				continue
+			}
+			delta := time.Since(created.Created)
+			expireInt, _ := strconv.Atoi(v)
+			if int(delta.Seconds()) <= expireInt {
+				remaining := expireInt - int(delta.Seconds())
+				if _, ok := ctr.Labels["build"]; ok && remaining <= DamaConfig.Notify.ExpireWarning {
+					warnExpiry(ctr, remaining)
 				}
+				continue
+			}
+			if job {
+				client.KillContainer(docker.KillContainerOptions{ID: ctr.ID})
+				cleanupActions.WithLabelValues("job_killed").Inc()
+				continue
+			}
+			if _, ok := ctr.Labels["build"]; ok {
+				db.HDel("wsPort", ctr.Labels["user"])
 			}
+			cleanup(ctr, "expired")
 		}
 		time.Sleep(time.Second * 10)
 	}
 }
 
+// cleanup removes a container for cleanContainers and counts the action, or a failure when it can't be removed
+func cleanup(ctr docker.APIContainers, action string) {
+	if err := removeContainer(ctr); err != nil {
+		action = "failed"
+	}
+	cleanupActions.WithLabelValues(action).Inc()
+}
+
 // stringInSlice is to check if string exists in slice
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
@@ -222,14 +397,114 @@ func detectImg() {
 	}
 }
 
-// dirSize checks to see if upload directory has reached it's max size before uploading new files
+// dirSize walks the upload directory to calculate it's total size, only used to seed the cached usage counter
 func dirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		if !info.IsDir() {
 			size += info.Size()
 		}
-		return err
+		return nil
 	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
 	return size, err
 }
+
+// getUsage returns the cached workspace usage for a user, seeding the counter with dirSize when it's missing.
+// Running containers write to the workspace directly, so their users counter is recalculated once a minute.
+func getUsage(name string) (int64, error) {
+	used, err := db.HGet("usage", name).Int64()
+	if err == nil && !staleUsage(name) {
+		return used, nil
+	}
+	used, err = dirSize(pwd + "/upload/" + name)
+	if err != nil {
+		return 0, err
+	}
+	db.HSet("usage", name, used)
+	return used, nil
+}
+
+// staleUsage reports if the cached usage of a user with a running container wasn't recalculated in the last minute
+func staleUsage(name string) bool {
+	if fresh, _ := db.SetNX("usage_fresh_"+name, 1, time.Minute).Result(); !fresh {
+		return false
+	}
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": {"dama", "user=" + name}}})
+	return err == nil && len(ctrs) > 0
+}
+
+// errQuota is returned by quotaWriter when a write would go over the workspace size limit
+var errQuota = errors.New("Workspace size limit reached")
+
+// quotaWriter reserves quota in the usage counter before bytes are written, so concurrent uploads can't use the
+// same remaining quota. Reservations are taken in 1MB steps and credit is quota freed by the upload, like an
+// overwritten file. done settles the counter to the bytes written.
+type quotaWriter struct {
+	w        io.Writer
+	name     string
+	credit   int64
+	reserved int64
+	written  int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if need := q.written + int64(len(p)) - q.reserved; need > 0 {
+		if err := q.reserve(need); err != nil {
+			return 0, err
+		}
+	}
+	n, err := q.w.Write(p)
+	q.written += int64(n)
+	return n, err
+}
+
+// reserve adds at least n bytes to the usage counter, or nothing when they don't fit in the limit
+func (q *quotaWriter) reserve(n int64) error {
+	limit := int64(DamaConfig.UploadSize) + q.credit
+	steps := []int64{n}
+	if n < 1<<20 {
+		steps = []int64{1 << 20, n}
+	}
+	for _, step := range steps {
+		used, err := db.HIncrBy("usage", q.name, step).Result()
+		if err != nil {
+			return err
+		}
+		if used <= limit {
+			q.reserved += step
+			return nil
+		}
+		db.HIncrBy("usage", q.name, -step)
+	}
+	return errQuota
+}
+
+// done returns the unused reservation, and the credit when the upload succeeded, or the whole reservation when it failed
+func (q *quotaWriter) done(ok bool) {
+	if ok {
+		addUsage(q.name, q.written-q.reserved-q.credit)
+	} else {
+		addUsage(q.name, -q.reserved)
+	}
+}
+
+// addUsage increments or decrements the cached workspace usage counter for a user
+func addUsage(name string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if exist, _ := db.HExists("usage", name).Result(); exist {
+		db.HIncrBy("usage", name, delta)
+	}
+}
+
+// resetUsage drops the cached usage counter so it's recalculated, containers can write to the workspace directly
+func resetUsage(name string) {
+	db.HDel("usage", name)
+}
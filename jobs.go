@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// Batch job statuses
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobDir returns the directory where job logs are kept after the container is gone
+func jobDir(user, id string) string {
+	return filepath.Clean(pwd + "/jobs/" + user + "/" + filepath.Base(id))
+}
+
+// saveJob stores a job record for a user
+func saveJob(user string, job *data.Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return db.HSet(user+"_jobs", job.ID, b).Err()
+}
+
+// getJob returns a job record for a user
+func getJob(user, id string) (*data.Job, error) {
+	raw, err := db.HGet(user+"_jobs", id).Result()
+	if err != nil {
+		return nil, errors.New("Job " + id + " not found")
+	}
+	job := &data.Job{}
+	err = json.Unmarshal([]byte(raw), job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// runJob writes the job script for a Damafile and queues it to start as a non-interactive batch container,
+// job can carry the schedule or pipeline the job is ran for
+func runJob(user string, df *data.Damafile, job *data.Job) (*data.Job, error) {
+	img := df.Image
+	if img == "" {
+		img = DamaConfig.Images[0]
+	}
+	if !checkImg(img) {
+		return nil, errors.New(img + " Image not found")
+	}
+	job.ID = genToken()
+	job.Project = df.Project
+	job.Image = img
+	job.Status = jobQueued
+	job.Submitted = time.Now().UTC().Format(time.RFC3339)
+	err := writeScript(pwd+"/upload/"+user+"/.dama-"+job.ID, jobTmpl, df)
+	if err != nil {
+		return nil, err
+	}
+	setEnvs(user, df.Env)
+	err = saveJob(user, job)
+	if err != nil {
+		return nil, err
+	}
+	go startJob(user, job, df)
+	return job, nil
+}
+
+// startJob waits for admission in the queue, then creates and starts the job container
+func startJob(user string, job *data.Job, df *data.Damafile) {
+	fail := func(err error) {
+		job.Status = jobFailed
+		job.Error = err.Error()
+		saveJob(user, job)
+		os.Remove(pwd + "/upload/" + user + "/.dama-" + job.ID)
+	}
+	release, err := admit(context.Background(), user, kindBatch)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer release()
+	labels := map[string]string{
+		"dama":   "dama",
+		"user":   user,
+		"job":    job.ID,
+		"expire": DamaConfig.JobExpire,
+	}
+	err = prepareS3(user, df.AWSs3, labels)
+	if err != nil {
+		fail(err)
+		return
+	}
+	env := mergeEnv(containerEnv(user, job.ID), append(df.Env, "JOB_ID="+job.ID))
+	env = trackingEnv(user, env, labels)
+	binds := []string{filepath.Clean(pwd+"/upload/"+user) + ":/root/workspace:rw"}
+	hostConfig := &docker.HostConfig{Privileged: false, Binds: binds}
+	opts := docker.CreateContainerOptions{Config: &docker.Config{CPUShares: DamaConfig.Docker.CPUShares, Memory: DamaConfig.Docker.Memory, Cmd: []string{"/bin/bash", "/root/workspace/.dama-" + job.ID}, Hostname: job.ID, Image: job.Image, Labels: labels, Env: env}, HostConfig: hostConfig}
+	ctr, err := client.CreateContainer(opts)
+	if err != nil {
+		fail(err)
+		return
+	}
+	job.Container = ctr.ID
+	job.Status = jobRunning
+	err = saveJob(user, job)
+	if err != nil {
+		client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
+		fail(err)
+		return
+	}
+	err = client.StartContainer(ctr.ID, hostConfig)
+	if err != nil {
+		client.RemoveContainer(docker.RemoveContainerOptions{ID: ctr.ID, Force: true})
+		fail(err)
+		return
+	}
+	go waitJob(user, job.ID, ctr.ID)
+}
+
+// waitJob waits for a job container to exit, then keeps it's exit code, duration and logs before removing it
+func waitJob(user, id, ctrID string) {
+	code, err := client.WaitContainer(ctrID)
+	job, jerr := getJob(user, id)
+	if jerr != nil {
+		job = &data.Job{ID: id, Container: ctrID}
+	}
+	if err != nil {
+		job.Status = jobFailed
+		job.Error = err.Error()
+	} else {
+		job.ExitCode = code
+		if code == 0 {
+			job.Status = jobSucceeded
+		} else {
+			job.Status = jobFailed
+		}
+	}
+	insp, ierr := client.InspectContainer(ctrID)
+	if err == nil && ierr == nil {
+		job.Started = insp.State.StartedAt.UTC().Format(time.RFC3339)
+		job.Finished = insp.State.FinishedAt.UTC().Format(time.RFC3339)
+		job.Duration = insp.State.FinishedAt.Sub(insp.State.StartedAt).Seconds()
+		if insp.State.OOMKilled {
+			job.Error = "OOM killed"
+		}
+	}
+	err = saveJobLogs(user, id, ctrID)
+	if err != nil {
+		logger.Error("saving job logs failed", zap.String("user", user), zap.String("job", id), zap.Error(err))
+	}
+	err = saveJob(user, job)
+	if err != nil {
+		logger.Error("saving job failed", zap.String("user", user), zap.String("job", id), zap.Error(err))
+	}
+	labels := map[string]string{"user": user}
+	if ierr == nil {
+		labels = insp.Config.Labels
+	}
+	removeContainer(docker.APIContainers{ID: ctrID, Labels: labels})
+	os.Remove(pwd + "/upload/" + user + "/.dama-" + id)
+	notifyJob(user, job)
+	scheduleDone(user, job)
+}
+
+// notifyJob sends the job.finished event of a finished batch job
+func notifyJob(user string, job *data.Job) {
+	text := fmt.Sprintf("Job %s of %s %s with exit code %d", job.ID, user, job.Status, job.ExitCode)
+	if job.Error != "" {
+		text += ": " + job.Error
+	}
+	notify(user, eventJobFinished, text, job)
+}
+
+// saveJobLogs copies stdout and stderr of a job container to the job directory
+func saveJobLogs(user, id, ctrID string) error {
+	dir := jobDir(user, id)
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, n := range []string{"output", "stdout", "stderr"} {
+		f, err := os.Create(dir + "/" + n + ".log")
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	return client.Logs(docker.LogsOptions{
+		Container:    ctrID,
+		OutputStream: io.MultiWriter(files[0], files[1]),
+		ErrorStream:  io.MultiWriter(files[0], files[2]),
+		Stdout:       true,
+		Stderr:       true,
+	})
+}
+
+// resumeJobs is ran when starting up to watch jobs that were running before a restart
+func resumeJobs() {
+	for user := range getAccounts() {
+		jobs, err := db.HGetAll(user + "_jobs").Result()
+		if err != nil {
+			continue
+		}
+		for id, raw := range jobs {
+			var job data.Job
+			if json.Unmarshal([]byte(raw), &job) != nil {
+				continue
+			}
+			if job.Status == jobQueued {
+				job.Status = jobFailed
+				job.Error = "Server restarted before job started"
+				saveJob(user, &job)
+				continue
+			}
+			if job.Status != jobRunning {
+				continue
+			}
+			if _, err := client.InspectContainer(job.Container); err != nil {
+				job.Status = jobFailed
+				job.Error = "Job container no longer exists"
+				saveJob(user, &job)
+				continue
+			}
+			go waitJob(user, id, job.Container)
+		}
+	}
+}
+
+// submitJob route runs a Damafile as a batch job
+func submitJob(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	df := &data.Damafile{}
+	if err := c.Bind(df); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	job, err := runJob(name, df, &data.Job{})
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(201, job)
+}
+
+// listJobs route lists all job records for a user, newest first
+func listJobs(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	all, err := db.HGetAll(name + "_jobs").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	jobs := []data.Job{}
+	for _, raw := range all {
+		var job data.Job
+		if err := json.Unmarshal([]byte(raw), &job); err == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Submitted > jobs[j].Submitted
+	})
+	c.JSON(200, jobs)
+}
+
+// showJob route returns a single job record
+func showJob(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	job, err := getJob(name, c.Param("id"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	c.JSON(200, job)
+}
+
+// jobLogs route returns the output of a job, stream can be output, stdout or stderr
+func jobLogs(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	job, err := getJob(name, c.Param("id"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	stream := c.DefaultQuery("stream", "output")
+	if stream != "output" && stream != "stdout" && stream != "stderr" {
+		c.String(400, "stream needs to be output, stdout or stderr")
+		return
+	}
+	if job.Status == jobRunning {
+		opts := docker.LogsOptions{Container: job.Container, Stdout: stream != "stderr", Stderr: stream != "stdout"}
+		if stream != "stderr" {
+			opts.OutputStream = c.Writer
+		}
+		if stream != "stdout" {
+			opts.ErrorStream = c.Writer
+		}
+		c.Status(200)
+		client.Logs(opts)
+		return
+	}
+	path := jobDir(name, job.ID) + "/" + stream + ".log"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		c.String(404, "No logs for job "+job.ID)
+		return
+	}
+	c.File(path)
+}
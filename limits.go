@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// bucket is a token bucket refilled at a rate up to a burst
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens gained since the last refill, a new bucket starts full
+func (b *bucket) refill(rate float64, burst int, now time.Time) {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// wait returns 0 when a token is available, or how long until one is
+func (b *bucket) wait(rate float64) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// limitBucket returns the bucket of a key, creating it when it's missing
+func limitBucket(key string) *bucket {
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{}
+		limiter.buckets[key] = b
+	}
+	return b
+}
+
+// limiter keeps the token buckets and in-flight requests of deployments
+var limiter = struct {
+	sync.Mutex
+	buckets  map[string]*bucket
+	inflight map[string]int
+}{buckets: make(map[string]*bucket), inflight: make(map[string]int)}
+
+// validLimits checks no limit is negative
+func validLimits(l data.Limits) error {
+	if l.Rate < 0 || l.Burst < 0 || l.KeyRate < 0 || l.KeyBurst < 0 || l.MaxBody < 0 || l.Concurrency < 0 {
+		return errors.New("Limits can't be negative")
+	}
+	return nil
+}
+
+// setLimits keeps limits of the users deployment in a field of the users hash
+func setLimits(name, field string, l data.Limits) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return db.HSet(name, field, b).Err()
+}
+
+// readLimits returns limits kept in a field of the users hash
+func readLimits(name, field string) data.Limits {
+	l := data.Limits{}
+	if raw, _ := db.HGet(name, field).Result(); raw != "" {
+		json.Unmarshal([]byte(raw), &l)
+	}
+	return l
+}
+
+// getLimits returns the limits of the users deployment from dama.yml with the ones the admin set replacing them
+func getLimits(name string) data.Limits {
+	l := readLimits(name, "limits")
+	admin := readLimits(name, "admin_limits")
+	if admin.Rate > 0 {
+		l.Rate = admin.Rate
+	}
+	if admin.Burst > 0 {
+		l.Burst = admin.Burst
+	}
+	if admin.KeyRate > 0 {
+		l.KeyRate = admin.KeyRate
+	}
+	if admin.KeyBurst > 0 {
+		l.KeyBurst = admin.KeyBurst
+	}
+	if admin.MaxBody > 0 {
+		l.MaxBody = admin.MaxBody
+	}
+	if admin.Concurrency > 0 {
+		l.Concurrency = admin.Concurrency
+	}
+	return l
+}
+
+// tooManyRequests responds 429 with the seconds to wait in Retry-After
+func tooManyRequests(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.String(429, "Too many requests")
+}
+
+// limitRequest applies the body size, rate and concurrency limits of the owners deployment to a request,
+// the returned release has to be called when the request is done
+func limitRequest(c *gin.Context, owner, consumer string) (func(), bool) {
+	l := getLimits(owner)
+	if l.MaxBody > 0 {
+		if c.Request.ContentLength > l.MaxBody {
+			c.String(413, "Request body too large")
+			return nil, false
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, l.MaxBody)
+	}
+	limiter.Lock()
+	defer limiter.Unlock()
+	if l.Concurrency > 0 && limiter.inflight[owner] >= l.Concurrency {
+		tooManyRequests(c, time.Second)
+		return nil, false
+	}
+	// Both buckets are checked before a token is taken from either, so a rejected request doesn't spend any
+	now := time.Now()
+	var deployment, key *bucket
+	var wait time.Duration
+	if l.Rate > 0 {
+		deployment = limitBucket("deployment/" + owner)
+		deployment.refill(l.Rate, l.Burst, now)
+		wait = deployment.wait(l.Rate)
+	}
+	if l.KeyRate > 0 && consumer != "" {
+		key = limitBucket("key/" + owner + "/" + consumer)
+		key.refill(l.KeyRate, l.KeyBurst, now)
+		if w := key.wait(l.KeyRate); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		tooManyRequests(c, wait)
+		return nil, false
+	}
+	if deployment != nil {
+		deployment.tokens--
+	}
+	if key != nil {
+		key.tokens--
+	}
+	limiter.inflight[owner]++
+	return func() {
+		limiter.Lock()
+		defer limiter.Unlock()
+		limiter.inflight[owner]--
+		if limiter.inflight[owner] <= 0 {
+			delete(limiter.inflight, owner)
+		}
+	}, true
+}
+
+// showLimits route returns the limits in effect for a deployment
+func showLimits(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, getLimits(owner))
+}
+
+// adminLimits route sets limits for a deployment which replace the ones from dama.yml, 0 keeps the dama.yml limit
+func adminLimits(c *gin.Context) {
+	owner, ok := deploymentOwner(c)
+	if !ok {
+		return
+	}
+	l := &data.Limits{}
+	if err := c.Bind(l); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if err := validLimits(*l); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	err := setLimits(owner, "admin_limits", *l)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.JSON(200, getLimits(owner))
+}
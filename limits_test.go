@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketRejectsWhenEmpty(t *testing.T) {
+	b := &bucket{}
+	now := time.Now()
+	b.refill(1, 2, now)
+	for i := 0; i < 2; i++ {
+		if w := b.wait(1); w != 0 {
+			t.Fatalf("token %d: waiting %s on a full bucket", i, w)
+		}
+		b.tokens--
+	}
+	if w := b.wait(1); w != time.Second {
+		t.Fatalf("empty bucket waits %s, want 1s", w)
+	}
+	if b.tokens != 0 {
+		t.Fatalf("rejected request took a token, %v left", b.tokens)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	b := &bucket{}
+	now := time.Now()
+	b.refill(2, 4, now)
+	b.tokens = 0
+	b.refill(2, 4, now.Add(500*time.Millisecond))
+	if b.tokens != 1 {
+		t.Fatalf("%v tokens after 500ms at 2/s, want 1", b.tokens)
+	}
+	if w := b.wait(2); w != 0 {
+		t.Fatalf("waiting %s with a refilled token", w)
+	}
+	b.refill(2, 4, now.Add(time.Minute))
+	if b.tokens != 4 {
+		t.Fatalf("%v tokens after a minute, want the burst of 4", b.tokens)
+	}
+}
+
+func TestBucketDefaultBurst(t *testing.T) {
+	b := &bucket{}
+	b.refill(2.5, 0, time.Now())
+	if b.tokens != 3 {
+		t.Fatalf("new bucket without a burst has %v tokens, want the rounded up rate 3", b.tokens)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+)
+
+// targets maps the target query param to the label of the users container
+var targets = map[string]string{
+	"sandbox": "build",
+	"deploy":  "API",
+}
+
+// userContainer returns the users container with a label like build or API
+func userContainer(name, label string) (*docker.APIContainers, error) {
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{All: true, Filters: map[string][]string{"label": {"user=" + name, label}}})
+	if err != nil {
+		return nil, err
+	}
+	if len(ctrs) == 0 {
+		return nil, errors.New("No container running")
+	}
+	return &ctrs[0], nil
+}
+
+// savedLogsKept is how many logs of removed containers are kept for each user and target
+const savedLogsKept = 10
+
+// savedLogsDir returns the directory with the logs of a users removed containers
+func savedLogsDir(user string) string {
+	return filepath.Clean(pwd + "/logs/" + user)
+}
+
+// savedLogsPath returns where the logs of a removed sandbox or deploy container are kept, the time it was removed
+// and it's short ID are in the name so every container has it's own file
+func savedLogsPath(user, label, id string) string {
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return filepath.Join(savedLogsDir(user), label+"-"+time.Now().UTC().Format("20060102T150405.000000000")+"-"+id+".log")
+}
+
+// savedLogs returns the kept logs of a users removed containers with a label, oldest first
+func savedLogs(user, label string) []string {
+	fis, _ := ioutil.ReadDir(savedLogsDir(user))
+	var paths []string
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), label+"-") && strings.HasSuffix(fi.Name(), ".log") {
+			paths = append(paths, filepath.Join(savedLogsDir(user), fi.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// findSavedLogs returns the kept logs of the container with an ID prefix, or of the last removed container
+func findSavedLogs(user, label, id string) string {
+	paths := savedLogs(user, label)
+	for i := len(paths) - 1; i >= 0; i-- {
+		name := strings.TrimSuffix(filepath.Base(paths[i]), ".log")
+		if strings.HasPrefix(name[strings.LastIndex(name, "-")+1:], id) {
+			return paths[i]
+		}
+	}
+	return ""
+}
+
+// saveContainerLogs keeps the logs of a sandbox or deploy container before it's removed, batch jobs keep their own logs
+func saveContainerLogs(ctr docker.APIContainers) error {
+	var label string
+	for _, l := range targets {
+		if _, ok := ctr.Labels[l]; ok {
+			label = l
+		}
+	}
+	if label == "" {
+		return nil
+	}
+	user := ctr.Labels["user"]
+	path := savedLogsPath(user, label, ctr.ID)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if paths := savedLogs(user, label); len(paths) > savedLogsKept {
+		for _, old := range paths[:len(paths)-savedLogsKept] {
+			os.Remove(old)
+		}
+	}
+	return client.Logs(docker.LogsOptions{
+		Container:    ctr.ID,
+		OutputStream: f,
+		ErrorStream:  f,
+		Stdout:       true,
+		Stderr:       true,
+		Timestamps:   true,
+	})
+}
+
+// logsStreamTime is how long logs are followed, it ends before the servers write timeout
+const logsStreamTime = writeTimeout - 30*time.Second
+
+// logsReconnect is the trailer set when following ended at logsStreamTime and the container is still running,
+// clients reconnect with since set to the last timestamp they got
+const logsReconnect = "X-Dama-Reconnect"
+
+// parseSince reads a since query param as a duration like 10m, RFC3339 time or unix seconds
+func parseSince(since string) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, since); err == nil {
+		return t, nil
+	}
+	if u, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(u, 0), nil
+	}
+	return time.Time{}, errors.New("since needs to be a duration, RFC3339 time or unix timestamp")
+}
+
+// flushWriter flushes every write so logs are streamed to the client as they come in, sse writes every line as an event
+type flushWriter struct {
+	w   gin.ResponseWriter
+	sse bool
+	buf []byte
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	if !f.sse {
+		n, err := f.w.Write(p)
+		f.w.Flush()
+		return n, err
+	}
+	f.buf = append(f.buf, p...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 {
+			break
+		}
+		_, err := f.w.Write([]byte("data: " + strings.TrimSuffix(string(f.buf[:i]), "\r") + "\n\n"))
+		if err != nil {
+			return 0, err
+		}
+		f.buf = f.buf[i+1:]
+	}
+	f.w.Flush()
+	return len(p), nil
+}
+
+// writeSavedLogs writes kept logs filtered by since and tail, the timestamp docker adds to each line is removed unless asked for
+func writeSavedLogs(w *flushWriter, path string, since time.Time, tail int, timestamps bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		split := strings.SplitN(line, " ", 2)
+		if !since.IsZero() {
+			if t, err := time.Parse(time.RFC3339Nano, split[0]); err == nil && t.Before(since) {
+				continue
+			}
+		}
+		if !timestamps && len(split) == 2 {
+			line = split[1]
+		}
+		lines = append(lines, line)
+	}
+	if tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	for _, line := range lines {
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// logs route streams the logs of the users sandbox or deploy container with chunked HTTP or SSE,
+// the logs kept from the last removed container are returned when it's no longer running and the container
+// query param returns the kept logs of an earlier one
+func logs(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	target := c.DefaultQuery("target", "deploy")
+	label, ok := targets[target]
+	if !ok {
+		c.String(400, "target needs to be deploy or sandbox")
+		return
+	}
+	since, err := parseSince(c.Query("since"))
+	if err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	tail := -1
+	if t := c.Query("tail"); t != "" && t != "all" {
+		tail, err = strconv.Atoi(t)
+		if err != nil || tail < 0 {
+			c.String(400, "tail needs to be a number or all")
+			return
+		}
+	}
+	follow := c.Query("follow") == "true"
+	timestamps := c.Query("timestamps") == "true"
+	sse := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	w := &flushWriter{w: c.Writer, sse: sse}
+
+	container := c.Query("container")
+	ctr, err := userContainer(name, label)
+	if err != nil || container != "" {
+		path := findSavedLogs(name, label, container)
+		if path == "" {
+			if err == nil {
+				err = errors.New("No logs kept for container " + container)
+			}
+			c.String(404, err.Error())
+			return
+		}
+		setLogHeaders(c, sse)
+		writeSavedLogs(w, path, since, tail, timestamps)
+		return
+	}
+	ctx := c.Request.Context()
+	if follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, logsStreamTime)
+		defer cancel()
+		c.Header("Trailer", logsReconnect)
+	}
+	opts := docker.LogsOptions{
+		Context:      ctx,
+		Container:    ctr.ID,
+		OutputStream: w,
+		ErrorStream:  w,
+		Stdout:       true,
+		Stderr:       true,
+		Follow:       follow,
+		Timestamps:   timestamps,
+		Tail:         "all",
+	}
+	if tail >= 0 {
+		opts.Tail = strconv.Itoa(tail)
+	}
+	if !since.IsZero() {
+		opts.Since = since.Unix()
+	}
+	setLogHeaders(c, sse)
+	client.Logs(opts)
+	if follow && ctx.Err() == context.DeadlineExceeded {
+		c.Writer.Header().Set(logsReconnect, "true")
+	}
+}
+
+// setLogHeaders sets the content type for plain or SSE log streams
+func setLogHeaders(c *gin.Context, sse bool) {
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(200)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
@@ -17,9 +18,13 @@ import (
 	"go.uber.org/zap"
 )
 
+// writeTimeout is the longest a response can take, streams that run longer are cut off by the server
+const writeTimeout = 600 * time.Second
+
 var (
 	client  *docker.Client
 	db      *redis.Client
+	logger  *zap.Logger
 	pwd     string
 	version string
 )
@@ -45,20 +50,26 @@ func main() {
 		DB:         DamaConfig.DB.DB,
 		MaxRetries: DamaConfig.DB.MaxRetries,
 	})
+	db.WrapProcess(countRedisErrors)
 	_, err = db.Ping().Result()
 	if err != nil {
 		panic(err)
 	}
 	detectImg()
 	pwd, _ = os.Getwd()
+	logger, _ = zap.NewProduction()
 
 	go cleanContainers()
+	go watchMetrics()
+	go watchOOM()
+	resumeJobs()
+	resumePipelines()
+	loadSchedules()
 
 	if !DamaConfig.HTTPS.Debug {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.New()
-	logger, _ := zap.NewProduction()
 	secureConfig := secure.New(secure.Config{
 		SSLRedirect:           true,
 		STSSeconds:            315360000,
@@ -73,8 +84,11 @@ func main() {
 	})
 	r.GET("/favicon.ico", gin.Recovery(), secureConfig)
 	r.Use(gin.Recovery(), ginzap.Ginzap(logger, time.RFC3339, false), secureConfig)
+	r.Use(requestID, instrument, hostRouter)
 	r.GET("/api/*name", api)
 	r.POST("/api/*name", api)
+	r.POST("/track/:token", track)
+	r.GET("/metrics", promMetrics)
 	r.POST("/create-user", createUser)
 	r.GET("/expire", expire)
 	r.GET("/images", func(c *gin.Context) {
@@ -91,8 +105,58 @@ func main() {
 	auth.POST("/create", create)
 	auth.POST("/deploy", deploy)
 	auth.POST("/uploads", uploads)
+	auth.GET("/workspace/usage", usage)
 	auth.GET("/download", download)
+	auth.GET("/logs", logs)
+	auth.POST("/exec", execCmd)
+	auth.POST("/shares", shareSession)
+	auth.GET("/shares", listShares)
+	auth.GET("/shares/audit", shareAudit)
+	auth.DELETE("/shares/:user", revokeShare)
+	auth.GET("/sessions", listRecordings)
+	auth.GET("/sessions/:id", downloadRecording)
+	auth.GET("/forward", forward)
+	auth.GET("/stats", containerStats)
+	auth.GET("/stats/oom", listOOM)
+	auth.POST("/domains", addDomain)
+	auth.GET("/domains", listDomains)
+	auth.DELETE("/domains/:host", removeDomain)
+	auth.POST("/deployments/:name/keys", createAPIKey)
+	auth.GET("/deployments/:name/keys", listAPIKeys)
+	auth.DELETE("/deployments/:name/keys/:key", deleteAPIKey)
+	auth.GET("/deployments/:name/limits", showLimits)
+	auth.PUT("/deployments/:name/limits", adminOnly, adminLimits)
+	auth.GET("/deployments/:name/captures", downloadCaptures)
+	auth.Any("/sandbox/:user/:service/*path", sandboxService)
+	auth.POST("/webhooks", createWebhook)
+	auth.GET("/webhooks", listWebhooks)
+	auth.GET("/webhooks/deliveries", webhookDeliveries)
+	auth.DELETE("/webhooks/:name", deleteWebhook)
+	auth.POST("/webhooks/:name/test", testWebhook)
+	auth.GET("/s3", s3Transfers)
 	auth.POST("/envs", envs)
+	auth.POST("/artifacts", registerArtifact)
+	auth.GET("/artifacts", listArtifacts)
+	auth.GET("/artifacts/:ref", showArtifact)
+	auth.GET("/artifacts/:ref/download", downloadArtifact)
+	auth.POST("/jobs", submitJob)
+	auth.GET("/jobs", listJobs)
+	auth.GET("/jobs/:id", showJob)
+	auth.GET("/jobs/:id/logs", jobLogs)
+	auth.POST("/schedules", createSchedule)
+	auth.GET("/schedules", listSchedules)
+	auth.GET("/schedules/:name", showSchedule)
+	auth.DELETE("/schedules/:name", deleteSchedule)
+	auth.POST("/pipelines", submitPipeline)
+	auth.GET("/pipelines", listPipelines)
+	auth.GET("/pipelines/:id", showPipeline)
+	auth.GET("/experiments", listExperiments)
+	auth.GET("/experiments/:project", showExperiment)
+	auth.GET("/experiments/:project/:run", showRun)
+	auth.GET("/queue", queuePosition)
+	auth.GET("/admin/queue", adminOnly, adminQueue)
+	auth.GET("/admin/audit", adminOnly, adminAudit)
+	auth.GET("/admin/webhooks/deliveries", adminOnly, adminDeliveries)
 
 	// Set http server timeouts and idle connections
 	http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost = 200
@@ -100,7 +164,7 @@ func main() {
 		Addr:           DamaConfig.HTTPS.Listen + ":" + DamaConfig.HTTPS.Port,
 		Handler:        r,
 		ReadTimeout:    120 * time.Second,
-		WriteTimeout:   600 * time.Second,
+		WriteTimeout:   writeTimeout,
 		MaxHeaderBytes: 1 << 20,
 	}
 	if _, err := os.Stat(DamaConfig.HTTPS.Pem); os.IsNotExist(err) {
@@ -112,8 +176,19 @@ func main() {
 		os.Exit(1)
 	}
 
+	certFile, keyFile := DamaConfig.HTTPS.Pem, DamaConfig.HTTPS.Key
+	if DamaConfig.HTTPS.CertDir != "" {
+		certs, err := newCertStore(DamaConfig.HTTPS.CertDir, DamaConfig.HTTPS.Pem, DamaConfig.HTTPS.Key)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		s.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		certFile, keyFile = "", ""
+	}
+
 	fmt.Println("dama is sponsored by Perlogix, built on " + version)
-	err = s.ListenAndServeTLS(DamaConfig.HTTPS.Pem, DamaConfig.HTTPS.Key)
+	err = s.ListenAndServeTLS(certFile, keyFile)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// Pipeline step statuses besides the batch job statuses
+const (
+	stepPending = "pending"
+	stepSkipped = "skipped"
+)
+
+// validateSteps checks that step names are unique, needs exist and the steps don't depend on each other in a cycle
+func validateSteps(df *data.Damafile) error {
+	if len(df.Steps) == 0 {
+		return errors.New("No steps in dama.yml")
+	}
+	steps := make(map[string]data.Step)
+	for _, s := range df.Steps {
+		if !validName.MatchString(s.Name) {
+			return errors.New("Step name can only contain letters, numbers, dots, dashes and underscores")
+		}
+		if _, ok := steps[s.Name]; ok {
+			return errors.New("Step " + s.Name + " is defined more than once")
+		}
+		if !s.Deploy && s.Cmd == "" && s.Python == "" {
+			return errors.New("Step " + s.Name + " needs a cmd, python or deploy")
+		}
+		if s.Image != "" && !checkImg(s.Image) {
+			return errors.New(s.Image + " Image not found")
+		}
+		steps[s.Name] = s
+	}
+	for _, s := range df.Steps {
+		for _, n := range s.Needs {
+			if _, ok := steps[n]; !ok {
+				return errors.New("Step " + s.Name + " needs unknown step " + n)
+			}
+		}
+		if s.When != nil {
+			if s.When.Step != "" && !stringInSlice(s.When.Step, s.Needs) {
+				return errors.New("Step " + s.Name + " has a condition on " + s.When.Step + " which needs to be in it's needs")
+			}
+			switch s.When.Op {
+			case "", ">", ">=", "<", "<=", "==", "!=":
+			default:
+				return errors.New("Step " + s.Name + " has an unknown condition op " + s.When.Op)
+			}
+		}
+	}
+	// Depth first search for cycles, 1 is visiting and 2 is done
+	state := make(map[string]int)
+	var visit func(string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return errors.New("Steps have a dependency cycle through " + name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, n := range steps[name].Needs {
+			if err := visit(n); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, s := range df.Steps {
+		if err := visit(s.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// savePipeline stores a pipeline for a user
+func savePipeline(user string, p *data.Pipeline) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return db.HSet(user+"_pipelines", p.ID, b).Err()
+}
+
+// getPipeline returns a pipeline for a user
+func getPipeline(user, id string) (*data.Pipeline, error) {
+	raw, err := db.HGet(user+"_pipelines", id).Result()
+	if err != nil {
+		return nil, errors.New("Pipeline " + id + " not found")
+	}
+	p := &data.Pipeline{}
+	err = json.Unmarshal([]byte(raw), p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// evalCondition checks the file part of a step condition against a number in a JSON file in the workspace
+func evalCondition(user string, c *data.Condition) (bool, string) {
+	b, err := readWorkspace(user, c.File)
+	if err != nil {
+		return false, c.File + " not found"
+	}
+	if c.Key == "" {
+		return true, ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return false, c.File + " is not valid JSON"
+	}
+	for _, k := range strings.Split(c.Key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return false, c.Key + " not found in " + c.File
+		}
+		v = m[k]
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return false, c.Key + " in " + c.File + " is not a number"
+	}
+	var pass bool
+	switch c.Op {
+	case ">":
+		pass = n > c.Value
+	case "<":
+		pass = n < c.Value
+	case "<=":
+		pass = n <= c.Value
+	case "==":
+		pass = n == c.Value
+	case "!=":
+		pass = n != c.Value
+	default:
+		pass = n >= c.Value
+	}
+	if !pass {
+		return false, fmt.Sprintf("%s was %v", c.Key, n)
+	}
+	return true, ""
+}
+
+// stepReady checks if all needs of a step are done, then if the step should run or be skipped and why
+func stepReady(user string, step data.Step, status map[string]*data.StepStatus) (bool, bool, string) {
+	for _, n := range step.Needs {
+		if s := status[n].Status; s == stepPending || s == jobQueued || s == jobRunning {
+			return false, false, ""
+		}
+	}
+	var exitStep string
+	if step.When != nil && step.When.ExitCode != nil {
+		exitStep = step.When.Step
+		if exitStep == "" && len(step.Needs) > 0 {
+			exitStep = step.Needs[len(step.Needs)-1]
+		}
+	}
+	for _, n := range step.Needs {
+		st := status[n]
+		if st.Status == stepSkipped {
+			return true, false, n + " was skipped"
+		}
+		if n == exitStep {
+			if st.ExitCode != *step.When.ExitCode {
+				return true, false, fmt.Sprintf("%s exited with %d", n, st.ExitCode)
+			}
+			continue
+		}
+		if st.Status != jobSucceeded {
+			return true, false, n + " failed"
+		}
+	}
+	if step.When != nil && step.When.File != "" {
+		if ok, reason := evalCondition(user, step.When); !ok {
+			return true, false, reason
+		}
+	}
+	return true, true, ""
+}
+
+// stepDamafile returns the Damafile to run a step as a batch job, the project, env and image come from the pipeline
+func stepDamafile(df *data.Damafile, step data.Step) *data.Damafile {
+	img := step.Image
+	if img == "" {
+		img = df.Image
+	}
+	return &data.Damafile{
+		Project:    df.Project,
+		Env:        df.Env,
+		TimeFormat: df.TimeFormat,
+		Image:      img,
+		Pip:        strings.Join(strings.Fields(step.Pip), " "),
+		Cmd:        step.Cmd,
+		Python:     step.Python,
+	}
+}
+
+// runPipeline runs the steps of a pipeline as batch jobs in the order the needs allow, steps without pending needs run in parallel
+func runPipeline(user string, p *data.Pipeline) {
+	for {
+		status := make(map[string]*data.StepStatus)
+		for i := range p.Steps {
+			status[p.Steps[i].Name] = &p.Steps[i]
+		}
+		changed := false
+		for i, step := range p.Damafile.Steps {
+			st := &p.Steps[i]
+			switch st.Status {
+			case jobQueued, jobRunning:
+				job, err := getJob(user, st.Job)
+				if err != nil {
+					st.Status = jobFailed
+					st.Reason = err.Error()
+					changed = true
+					continue
+				}
+				if job.Status != st.Status {
+					st.Status = job.Status
+					st.ExitCode = job.ExitCode
+					st.Reason = job.Error
+					changed = true
+				}
+			case stepPending:
+				ready, run, reason := stepReady(user, step, status)
+				if !ready {
+					continue
+				}
+				changed = true
+				if !run {
+					st.Status = stepSkipped
+					st.Reason = reason
+					continue
+				}
+				if step.Deploy {
+					df := p.Damafile
+					_, err := deployDamafile(context.Background(), user, &df)
+					if err != nil {
+						st.Status = jobFailed
+						st.Reason = err.Error()
+						continue
+					}
+					st.Status = jobSucceeded
+					continue
+				}
+				job, err := runJob(user, stepDamafile(&p.Damafile, step), &data.Job{Pipeline: p.ID, Step: step.Name})
+				if err != nil {
+					st.Status = jobFailed
+					st.Reason = err.Error()
+					continue
+				}
+				st.Job = job.ID
+				st.Status = job.Status
+			}
+		}
+		done := true
+		failed := false
+		for _, st := range p.Steps {
+			switch st.Status {
+			case stepPending, jobQueued, jobRunning:
+				done = false
+			case jobFailed:
+				failed = true
+			}
+		}
+		if done {
+			p.Status = jobSucceeded
+			if failed {
+				p.Status = jobFailed
+			}
+			p.Finished = time.Now().UTC().Format(time.RFC3339)
+		}
+		if changed || done {
+			if err := savePipeline(user, p); err != nil {
+				logger.Error("saving pipeline failed", zap.String("user", user), zap.String("pipeline", p.ID), zap.Error(err))
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// resumePipelines is ran when starting up to continue pipelines that were running before a restart
+func resumePipelines() {
+	for user := range getAccounts() {
+		all, err := db.HGetAll(user + "_pipelines").Result()
+		if err != nil {
+			continue
+		}
+		for _, raw := range all {
+			p := &data.Pipeline{}
+			if json.Unmarshal([]byte(raw), p) != nil || p.Status != jobRunning {
+				continue
+			}
+			go runPipeline(user, p)
+		}
+	}
+}
+
+// submitPipeline route validates the steps of a Damafile and starts running them as a pipeline
+func submitPipeline(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	df := &data.Damafile{}
+	if err := c.Bind(df); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if df.Image != "" && !checkImg(df.Image) {
+		c.String(404, df.Image+" Image not found")
+		return
+	}
+	if err := validateSteps(df); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	p := &data.Pipeline{
+		ID:        genToken(),
+		Project:   df.Project,
+		Status:    jobRunning,
+		Submitted: time.Now().UTC().Format(time.RFC3339),
+		Damafile:  *df,
+	}
+	for _, s := range df.Steps {
+		p.Steps = append(p.Steps, data.StepStatus{Name: s.Name, Status: stepPending})
+	}
+	setEnvs(name, df.Env)
+	if err := savePipeline(name, p); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	go runPipeline(name, p)
+	c.JSON(201, p)
+}
+
+// listPipelines route lists all pipelines for a user, newest first
+func listPipelines(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	all, err := db.HGetAll(name + "_pipelines").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	pipelines := []data.Pipeline{}
+	for _, raw := range all {
+		var p data.Pipeline
+		if err := json.Unmarshal([]byte(raw), &p); err == nil {
+			pipelines = append(pipelines, p)
+		}
+	}
+	sort.Slice(pipelines, func(i, j int) bool {
+		return pipelines[i].Submitted > pipelines[j].Submitted
+	})
+	c.JSON(200, pipelines)
+}
+
+// showPipeline route returns a pipeline with the status of every step
+func showPipeline(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	p, err := getPipeline(name, c.Param("id"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	c.JSON(200, p)
+}
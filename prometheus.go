@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dama_http_requests_total",
+		Help: "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dama_http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dama_api_requests_total",
+		Help: "Requests to deployed APIs by deployment and status code.",
+	}, []string{"deployment", "code"})
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dama_api_request_duration_seconds",
+		Help:    "Proxy latency of deployed APIs by deployment.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"deployment"})
+	apiInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dama_api_requests_in_flight",
+		Help: "Requests in progress to deployed APIs by deployment.",
+	}, []string{"deployment"})
+	cleanupActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dama_cleanup_actions_total",
+		Help: "Containers removed or killed by the cleanup loop by action.",
+	}, []string{"action"})
+	oomKills = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dama_oom_kills_total",
+		Help: "Containers killed for running out of memory by type.",
+	}, []string{"type"})
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dama_redis_errors_total",
+		Help: "Failed Redis commands by command.",
+	}, []string{"command"})
+
+	containersDesc = prometheus.NewDesc("dama_containers",
+		"Running containers by type: build, API or job.", []string{"type"}, nil)
+	workspaceUsedDesc = prometheus.NewDesc("dama_workspace_used_bytes",
+		"Workspace usage by user.", []string{"user"}, nil)
+	workspaceLimitDesc = prometheus.NewDesc("dama_workspace_limit_bytes",
+		"Workspace size limit of every user.", nil, nil)
+
+	metricsHandler = promhttp.Handler()
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, apiRequests, apiDuration, apiInFlight, cleanupActions, oomKills, redisErrors, damaCollector{})
+}
+
+// damaCollector reads container counts and workspace usage when metrics are scraped
+type damaCollector struct{}
+
+func (damaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- containersDesc
+	ch <- workspaceUsedDesc
+	ch <- workspaceLimitDesc
+}
+
+func (damaCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]float64{"build": 0, "API": 0, "job": 0}
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": {"dama"}}})
+	if err == nil {
+		for _, ctr := range ctrs {
+			for t := range counts {
+				if _, ok := ctr.Labels[t]; ok {
+					counts[t]++
+				}
+			}
+		}
+		for t, n := range counts {
+			ch <- prometheus.MustNewConstMetric(containersDesc, prometheus.GaugeValue, n, t)
+		}
+	}
+	users, _ := db.HKeys("accounts").Result()
+	for _, user := range users {
+		if used, err := getUsage(user); err == nil {
+			ch <- prometheus.MustNewConstMetric(workspaceUsedDesc, prometheus.GaugeValue, float64(used), user)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(workspaceLimitDesc, prometheus.GaugeValue, float64(DamaConfig.UploadSize))
+}
+
+// instrument middleware counts requests and their latency by route, requests without a route are "none"
+func instrument(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	route := c.FullPath()
+	if route == "" {
+		route = "none"
+	}
+	httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
+
+// observeAPI tracks a request to the owners deployment as in flight, the returned done records it's status and latency
+func observeAPI(c *gin.Context, owner string) func() {
+	start := time.Now()
+	apiInFlight.WithLabelValues(owner).Inc()
+	return func() {
+		apiInFlight.WithLabelValues(owner).Dec()
+		apiRequests.WithLabelValues(owner, strconv.Itoa(c.Writer.Status())).Inc()
+		apiDuration.WithLabelValues(owner).Observe(time.Since(start).Seconds())
+	}
+}
+
+// countRedisErrors wraps the Redis client to count failed commands, a missing key isn't a failure
+func countRedisErrors(process func(redis.Cmder) error) func(redis.Cmder) error {
+	return func(cmd redis.Cmder) error {
+		err := process(cmd)
+		if err != nil && err != redis.Nil {
+			redisErrors.WithLabelValues(cmd.Name()).Inc()
+		}
+		return err
+	}
+}
+
+// metricsAllowed reports if a scrape has Metrics.Token as a bearer token or the Basic Auth of an admin
+func metricsAllowed(c *gin.Context) bool {
+	token := DamaConfig.Metrics.Token
+	if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) == 1 {
+		return true
+	}
+	user, pass, ok := c.Request.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, found := getAccounts()[user]
+	return found && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1 && isAdmin(user)
+}
+
+// promMetrics route serves metrics in the Prometheus exposition format to scrapes with Metrics.Token or an admin login,
+// the metrics are labeled with user names so they're never public
+func promMetrics(c *gin.Context) {
+	if !metricsAllowed(c) {
+		if DamaConfig.Metrics.Token != "" {
+			c.Header("WWW-Authenticate", "Bearer")
+		} else {
+			c.Header("WWW-Authenticate", `Basic realm="dama"`)
+		}
+		c.String(401, "Unauthorized")
+		return
+	}
+	metricsHandler.ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// Container kinds admitted by the queue, lower priority values are admitted first
+const (
+	kindSandbox = "sandbox"
+	kindDeploy  = "deploy"
+	kindBatch   = "batch"
+)
+
+var priorities = map[string]int{
+	kindSandbox: 0,
+	kindDeploy:  1,
+	kindBatch:   2,
+}
+
+// replaces maps a container kind to the label of the users container it replaces when started
+var replaces = map[string]string{
+	kindSandbox: "build",
+	kindDeploy:  "API",
+}
+
+// queueEntry is a request waiting in the queue for a container to start
+type queueEntry struct {
+	id       string
+	user     string
+	kind     string
+	priority int
+	enqueued time.Time
+	ready    chan struct{}
+}
+
+// admissionQueue holds waiting requests and containers that were admitted but are still being created
+type admissionQueue struct {
+	sync.Mutex
+	waiting       []*queueEntry
+	starting      map[string]int
+	startingTotal int
+}
+
+var queue = &admissionQueue{starting: map[string]int{}}
+
+// admit waits in the queue until a container of kind can be started for user, release needs to be called once it's created
+func admit(ctx context.Context, user, kind string) (func(), error) {
+	e := &queueEntry{
+		id:       genToken(),
+		user:     user,
+		kind:     kind,
+		priority: priorities[kind],
+		enqueued: time.Now(),
+		ready:    make(chan struct{}),
+	}
+	queue.Lock()
+	queue.waiting = append(queue.waiting, e)
+	sort.SliceStable(queue.waiting, func(i, j int) bool {
+		return queue.waiting[i].priority < queue.waiting[j].priority
+	})
+	queue.Unlock()
+
+	release := func() {
+		queue.Lock()
+		queue.starting[user]--
+		queue.startingTotal--
+		queue.Unlock()
+		go dispatch()
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	// A nil channel never receives, so a Timeout of 0 waits until the entry is admitted or the request ends
+	var timeout <-chan time.Time
+	if DamaConfig.Queue.Timeout > 0 {
+		timeout = time.After(time.Duration(DamaConfig.Queue.Timeout) * time.Second)
+	}
+	dispatch()
+	for {
+		select {
+		case <-e.ready:
+			return release, nil
+		case <-ticker.C:
+			dispatch()
+		case <-ctx.Done():
+			if !queue.remove(e) {
+				release()
+			}
+			return nil, ctx.Err()
+		case <-timeout:
+			if !queue.remove(e) {
+				return release, nil
+			}
+			return nil, errors.New("Timed out waiting in queue to start " + kind)
+		}
+	}
+}
+
+// remove takes an entry out of the queue, it returns false if the entry was already admitted
+func (q *admissionQueue) remove(e *queueEntry) bool {
+	q.Lock()
+	defer q.Unlock()
+	for i, w := range q.waiting {
+		if w == e {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch admits waiting entries in priority order while the global and per user limits allow it
+func dispatch() {
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": {"dama"}}})
+	if err != nil {
+		return
+	}
+	queue.Lock()
+	defer queue.Unlock()
+	total := queue.startingTotal
+	perUser := make(map[string]int)
+	for user, n := range queue.starting {
+		perUser[user] = n
+	}
+	existing := make(map[string]bool)
+	for _, ctr := range ctrs {
+		user := ctr.Labels["user"]
+		total++
+		perUser[user]++
+		for _, label := range replaces {
+			if _, ok := ctr.Labels[label]; ok {
+				existing[user+"/"+label] = true
+			}
+		}
+	}
+	var waiting []*queueEntry
+	for _, e := range queue.waiting {
+		t, u := total, perUser[e.user]
+		// A container replacing the users existing one doesn't add to the running count
+		if existing[e.user+"/"+replaces[e.kind]] {
+			t--
+			u--
+		}
+		if (DamaConfig.Queue.MaxContainers > 0 && t >= DamaConfig.Queue.MaxContainers) ||
+			(DamaConfig.Queue.MaxUserContainers > 0 && u >= DamaConfig.Queue.MaxUserContainers) {
+			waiting = append(waiting, e)
+			continue
+		}
+		if !existing[e.user+"/"+replaces[e.kind]] {
+			total++
+			perUser[e.user]++
+		}
+		queue.starting[e.user]++
+		queue.startingTotal++
+		close(e.ready)
+	}
+	queue.waiting = waiting
+}
+
+// queueEntries returns the waiting entries with their position, filtered by user when it's not empty
+func queueEntries(user string) []data.QueueEntry {
+	queue.Lock()
+	defer queue.Unlock()
+	entries := []data.QueueEntry{}
+	for i, e := range queue.waiting {
+		if user != "" && e.user != user {
+			continue
+		}
+		entries = append(entries, data.QueueEntry{
+			ID:       e.id,
+			User:     e.user,
+			Kind:     e.kind,
+			Position: i + 1,
+			Enqueued: e.enqueued.UTC().Format(time.RFC3339),
+		})
+	}
+	return entries
+}
+
+// queuePosition route returns the users requests waiting in the queue
+func queuePosition(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	c.JSON(200, queueEntries(name))
+}
+
+// adminQueue route returns the whole queue with running container counts for admins
+func adminQueue(c *gin.Context) {
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": {"dama"}}})
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	queue.Lock()
+	starting := queue.startingTotal
+	queue.Unlock()
+	c.JSON(200, data.Queue{
+		Running:           len(ctrs),
+		Starting:          starting,
+		MaxContainers:     DamaConfig.Queue.MaxContainers,
+		MaxUserContainers: DamaConfig.Queue.MaxUserContainers,
+		Waiting:           queueEntries(""),
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// recorder writes a terminal session to an asciicast v2 file
+type recorder struct {
+	mu      sync.Mutex
+	f       *os.File
+	start   time.Time
+	pending []byte
+	size    int64
+	rec     data.Recording
+}
+
+// recordingPath returns where a users session recording is kept
+func recordingPath(user, id string) string {
+	return filepath.Clean(pwd + "/sessions/" + user + "/" + id + ".cast")
+}
+
+// saveRecording keeps a recordings details in the users hash
+func saveRecording(rec data.Recording) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return db.HSet(rec.User+"_sessions", rec.ID, b).Err()
+}
+
+// pruneRecordings removes the oldest recordings of a user so only RecordingKept are left
+func pruneRecordings(user string) {
+	all, err := db.HGetAll(user + "_sessions").Result()
+	if err != nil || len(all) <= DamaConfig.RecordingKept {
+		return
+	}
+	recs := []data.Recording{}
+	for id, raw := range all {
+		rec := data.Recording{ID: id}
+		json.Unmarshal([]byte(raw), &rec)
+		recs = append(recs, rec)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Started < recs[j].Started
+	})
+	for _, rec := range recs[:len(recs)-DamaConfig.RecordingKept] {
+		os.Remove(recordingPath(user, rec.ID))
+		db.HDel(user+"_sessions", rec.ID)
+	}
+}
+
+// newRecorder starts a recording of a users session when recording is on, nil is returned when it's off
+func newRecorder(user string) (*recorder, error) {
+	if DamaConfig.Recording != "output" && DamaConfig.Recording != "input" {
+		return nil, nil
+	}
+	start := time.Now()
+	rec := data.Recording{
+		ID:      genToken(),
+		User:    user,
+		Input:   DamaConfig.Recording == "input",
+		Started: start.UTC().Format(time.RFC3339),
+	}
+	path := recordingPath(user, rec.ID)
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0640)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": start.Unix(),
+		"env":       map[string]string{"TERM": "xterm-256color", "SHELL": "/bin/bash"},
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	_, err = f.Write(append(header, '\n'))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &recorder{f: f, start: start, size: int64(len(header) + 1), rec: rec}
+	saveRecording(rec)
+	pruneRecordings(user)
+	return r, nil
+}
+
+// event writes a single asciicast event with the seconds since the session started, events stop once the
+// recording reaches RecordingSize
+func (r *recorder) event(kind, text string) {
+	if r.rec.Trimmed {
+		return
+	}
+	b, err := json.Marshal([]interface{}{time.Since(r.start).Seconds(), kind, text})
+	if err != nil {
+		return
+	}
+	if r.size+int64(len(b))+1 > DamaConfig.RecordingSize {
+		r.rec.Trimmed = true
+		return
+	}
+	n, _ := r.f.Write(append(b, '\n'))
+	r.size += int64(n)
+}
+
+// output records terminal output, a character split between writes is kept until it's complete
+func (r *recorder) output(p []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, p...)
+	cut := len(r.pending)
+	for i := len(r.pending) - 1; i >= 0 && i >= len(r.pending)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(r.pending[i]) {
+			if !utf8.FullRune(r.pending[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	if cut > 0 {
+		r.event("o", string(r.pending[:cut]))
+		r.pending = append([]byte(nil), r.pending[cut:]...)
+	}
+}
+
+// input records what was typed when input recording is on
+func (r *recorder) input(p []byte) {
+	if r == nil || !r.rec.Input {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("i", string(p))
+}
+
+// resize records a change of the terminal size
+func (r *recorder) resize(cols, rows uint) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", strconv.FormatUint(uint64(cols), 10)+"x"+strconv.FormatUint(uint64(rows), 10))
+}
+
+// close finishes the recording and saves it's duration and size
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	if fi, err := r.f.Stat(); err == nil {
+		r.rec.Size = fi.Size()
+	}
+	r.f.Close()
+	r.rec.Finished = time.Now().UTC().Format(time.RFC3339)
+	r.rec.Duration = time.Since(r.start).Seconds()
+	saveRecording(r.rec)
+}
+
+// getRecording returns a single recording of the user
+func getRecording(user, id string) (*data.Recording, error) {
+	raw, err := db.HGet(user+"_sessions", id).Result()
+	if err != nil {
+		return nil, errors.New("Session not found")
+	}
+	rec := &data.Recording{}
+	err = json.Unmarshal([]byte(raw), rec)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// listRecordings route returns the users recorded terminal sessions, newest first
+func listRecordings(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	all, err := db.HGetAll(name + "_sessions").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	recs := []data.Recording{}
+	for _, raw := range all {
+		var rec data.Recording
+		if err := json.Unmarshal([]byte(raw), &rec); err == nil {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Started > recs[j].Started
+	})
+	c.JSON(200, recs)
+}
+
+// downloadRecording route returns the asciicast file of a recorded session
+func downloadRecording(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	rec, err := getRecording(name, c.Param("id"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	path := recordingPath(name, rec.ID)
+	if _, err := os.Stat(path); err != nil {
+		c.String(404, "Recording not found")
+		return
+	}
+	c.FileAttachment(path, rec.ID+".cast")
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
-	"text/template"
 
 	"github.com/gin-gonic/gin"
 	"github.com/perlogix/dama/data"
@@ -23,31 +26,27 @@ type User struct {
 	Role     string `json:"role"`
 }
 
-// api route is proxy requests to the right container based on name http param
+// api route is proxy requests to the right container based on name http param, deployments check their access policy
 func api(c *gin.Context) {
 	name := c.Param("name")
 	key := strings.Split(name, "/")[1]
-	var api string
-	if dpAPI, _ := db.HGet("deployedPort", key).Result(); dpAPI != "" {
-		api = "localhost:" + dpAPI
-	}
-	if api == "" {
-		if sbAPI, _ := db.HGet("sandboxPort", key).Result(); sbAPI != "" {
-			api = "localhost:" + sbAPI
-		}
-	}
-	if api == "" {
-		c.String(400, "API not found")
-		return
-	}
-	backendURL := &url.URL{Scheme: "http", Host: api}
-	p := httputil.NewSingleHostReverseProxy(backendURL)
 	var pathRewrite string
 	pathRewrite = strings.TrimPrefix(c.Request.URL.Path, "/api/"+key)
 	if pathRewrite == "" {
 		pathRewrite = "/"
 	}
-	c.Request.URL.Path = pathRewrite
+	serveAPI(c, key, pathRewrite)
+}
+
+// proxyTo proxies a request to a backend host with a rewritten path, websocket upgrades are proxied too
+func proxyTo(c *gin.Context, host, path string) {
+	c.Request.URL.Path = path
+	if wsutil.IsWebSocketRequest(c.Request) {
+		p := wsutil.NewSingleHostReverseProxy(&url.URL{Scheme: "ws", Host: host})
+		p.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+	p := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: host})
 	p.ServeHTTP(c.Writer, c.Request)
 }
 
@@ -56,6 +55,9 @@ func api(c *gin.Context) {
 func expire(c *gin.Context) {
 	user := c.Query("user")
 	expire := c.Query("expire")
+	a := audit(c, "user.expire", user)
+	a.detail("expire", expire)
+	defer a.emit()
 	if expire == "" || user == "" {
 		c.String(400, "Bad request")
 		return
@@ -68,11 +70,23 @@ func expire(c *gin.Context) {
 
 // createUser route is used to create a new user into Redis DB
 func createUser(c *gin.Context) {
+	a := audit(c, "user.create", "")
+	defer a.emit()
 	usr := &User{}
 	if err := c.Bind(usr); err != nil {
 		c.String(500, err.Error())
 		return
 	}
+	a.Target = usr.Username
+	a.detail("role", usr.Role)
+	if usr.Username == "" || usr.Username == DamaConfig.AdminUsername {
+		c.String(403, "Username can't be registered")
+		return
+	}
+	if usr.Role != "" && usr.Role != "user" {
+		c.String(403, "Only the user role can be registered")
+		return
+	}
 	if exist, _ := db.HGet("accounts", usr.Username).Result(); exist != "" {
 		c.String(400, "User already in DB")
 		return
@@ -106,6 +120,7 @@ func createUser(c *gin.Context) {
 // getAPI route is used to retrieve keys for sandbox and deploy APIs
 func getAPI(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
+	defer audit(c, "api.keys", name).emit()
 	deployAPI, _ := db.HGet(name, "deployed").Result()
 	sandAPI, _ := db.HGet(name, "sandbox").Result()
 	if deployAPI != "" && sandAPI != "" {
@@ -115,13 +130,30 @@ func getAPI(c *gin.Context) {
 	c.String(404, "")
 }
 
-// ws route is used to proxy ws & wss to the correct running gotty docker container
+// ws route is used to attach a terminal to the users sandbox, or proxy ws & wss to the correct running gotty docker container
 func ws(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
 	file := c.Request.Header.Get("File")
 	new := c.Request.Header.Get("New")
 	image := c.Request.Header.Get("Image")
 	port := c.Request.Header.Get("Port")
+	if owner := c.Request.Header.Get("Attach"); owner != "" {
+		defer audit(c, "terminal.attach", owner).emit()
+		if DamaConfig.Terminal == "gotty" {
+			c.String(400, "Shared sessions need the native terminal")
+			return
+		}
+		attachShared(c, name, owner)
+		return
+	}
+	a := audit(c, "terminal.open", name)
+	a.detail("image", image)
+	a.detail("new", strconv.FormatBool(new != ""))
+	defer a.emit()
+	if DamaConfig.Terminal != "gotty" {
+		attach(c, name, image, file, port, new != "")
+		return
+	}
 	var wsPort string
 	var backend string
 	if new != "" {
@@ -131,7 +163,13 @@ func ws(c *gin.Context) {
 	if new == "" && wsPort != "" {
 		backend = "localhost:" + wsPort
 	} else {
+		release, err := admit(c.Request.Context(), name, kindSandbox)
+		if err != nil {
+			c.String(503, err.Error())
+			return
+		}
 		ctr, err := createContainer(name, image, file, port, false)
+		release()
 		if err != nil {
 			c.String(500, err.Error())
 			return
@@ -157,42 +195,73 @@ func ws(c *gin.Context) {
 // deploy route is called when user requests a new deploy from their dama.yml
 func deploy(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
+	a := audit(c, "deploy", name)
+	defer a.emit()
 	df := &data.Damafile{}
 	if err := c.Bind(df); err != nil {
 		c.String(500, err.Error())
 		return
 	}
+	a.detail("project", df.Project)
+	a.detail("image", df.Image)
+	a.detail("model", df.Model)
+	a.detail("sha", df.Git.SHA)
 	if df.Image != "" && !checkImg(df.Image) {
 		c.String(404, df.Image+" Image not found")
 		return
 	}
-	path := pwd + "/upload/" + name
-	err := os.MkdirAll(path, 0755)
-	if err != nil {
-		c.String(500, err.Error())
+	if df.Model != "" {
+		if _, err := getArtifact(name, df.Model); err != nil {
+			c.String(404, err.Error())
+			return
+		}
+	}
+	if err := validAccess(df.Access); err != nil {
+		c.String(400, err.Error())
 		return
 	}
-	t := template.New("tmpl")
-	t, _ = t.Parse(tmpl)
-	f, _ := os.Create(path + "/.dama")
-	err = os.Chmod(path+"/.dama", 0755)
-	if err != nil {
-		c.String(500, err.Error())
+	if err := validLimits(df.Limits); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	if err := validCapture(df.Capture); err != nil {
+		c.String(400, err.Error())
 		return
 	}
-	err = t.Execute(f, df)
+	deployed, err := deployDamafile(c.Request.Context(), name, df)
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	f.Close()
-	if df.Env != nil {
-		var envs = make(map[string]interface{})
-		for _, e := range df.Env {
-			split := strings.Split(e, "=")
-			envs[split[0]] = split[1]
-		}
-		db.HMSet(name+"_env", envs)
+	c.String(201, deployed)
+}
+
+// deployDamafile writes the deploy script for a Damafile and replaces the users deployed container
+func deployDamafile(ctx context.Context, name string, df *data.Damafile) (string, error) {
+	if df.Model != "" {
+		db.HSet(name, "model", df.Model)
+	} else {
+		db.HDel(name, "model")
+	}
+	path := pwd + "/upload/" + name
+	err := writeScript(path+"/.dama", tmpl, df)
+	if err != nil {
+		return "", err
+	}
+	db.HMSet(name, map[string]interface{}{"run": genToken(), "sha": df.Git.SHA})
+	setS3(name, df.AWSs3)
+	setEnvs(name, df.Env)
+	err = setAccess(name, df.Access)
+	if err != nil {
+		return "", err
+	}
+	err = setLimits(name, "limits", df.Limits)
+	if err != nil {
+		return "", err
+	}
+	err = setCapture(name, df.Capture)
+	if err != nil {
+		return "", err
 	}
 	file := path + "/.dama"
 	image := df.Image
@@ -202,62 +271,125 @@ func deploy(c *gin.Context) {
 	} else {
 		port = df.Port
 	}
-	var ctr string
-	ctr, err = createContainer(name, image, file, port, true)
+	deployed, _ := db.HGet(name, "deployed").Result()
+	release, err := admit(ctx, name, kindDeploy)
 	if err != nil {
-		c.String(500, err.Error())
-		return
+		notify(name, eventDeployFailed, "Deploy of "+name+" failed: "+err.Error(), map[string]string{"api": deployed, "error": err.Error()})
+		return "", err
 	}
-	deployed, _ := db.HGet(name, "deployed").Result()
+	ctr, err := createContainer(name, image, file, port, true)
+	release()
+	if err != nil {
+		notify(name, eventDeployFailed, "Deploy of "+name+" failed: "+err.Error(), map[string]string{"api": deployed, "error": err.Error()})
+		return "", err
+	}
+	db.HSet("apiOwner", deployed, name)
 	db.HSet("deployedPort", deployed, strings.Split(ctr, ":")[1])
-	c.String(201, deployed)
+	go watchDeploy(name, deployed)
+	return deployed, nil
 }
 
 // uploads route is for uploading files to the users workspace directory
 func uploads(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
-	err := c.Request.ParseMultipartForm(32 << 20)
+	a := audit(c, "workspace.upload", "")
+	defer a.emit()
+	path := pwd + "/upload/" + name
+	err := os.MkdirAll(path, 0755)
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	path := pwd + "/upload/" + name
-	pathSize, err := dirSize(path)
+	used, err := getUsage(name)
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	if pathSize >= int64(DamaConfig.UploadSize) {
-		c.String(500, "Workspace size limit reached")
+	if used >= int64(DamaConfig.UploadSize) {
+		c.String(413, "Workspace size limit reached")
 		return
 	}
-	info, handler, err := c.Request.FormFile("uploadfile")
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	defer info.Close()
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err != nil {
+			c.String(500, "No uploadfile in request")
+			return
+		}
+		if part.FormName() == "uploadfile" && part.FileName() != "" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
 
-	file := path + "/" + filepath.Base(handler.Filename)
+	file := path + "/" + filepath.Base(part.FileName())
+	a.Target = filepath.Base(part.FileName())
 
-	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0640)
+	// An overwritten file gives back it's old size to the quota, a symlink only it's own
+	var oldSize int64
+	if fi, err := os.Lstat(file); err == nil && fi.Mode().IsRegular() {
+		oldSize = fi.Size()
+	}
+	tmp, err := ioutil.TempFile(path, ".upload-")
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	defer out.Close()
-	_, err = io.Copy(out, info)
+	defer os.Remove(tmp.Name())
+	quota := &quotaWriter{w: tmp, name: name, credit: oldSize}
+	uploaded := false
+	defer func() { quota.done(uploaded) }()
+	_, err = io.Copy(quota, part)
+	tmp.Close()
+	if err == errQuota {
+		c.String(413, err.Error())
+		return
+	}
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
+	err = os.Chmod(tmp.Name(), 0640)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	err = os.Rename(tmp.Name(), file)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	uploaded = true
 	c.String(201, "Uploaded")
 }
 
+// usage route returns used and remaining bytes of the users workspace
+func usage(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	defer audit(c, "workspace.usage", name).emit()
+	used, err := getUsage(name)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	remaining := int64(DamaConfig.UploadSize) - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.JSON(200, data.Usage{Used: used, Remaining: remaining, Limit: int64(DamaConfig.UploadSize)})
+}
+
 // download route is for downloading files from workspace directory
 func download(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
 	file := c.Query("file")
+	defer audit(c, "workspace.download", file).emit()
 	if file == "" {
 		c.String(500, "No file specified")
 		return
@@ -274,11 +406,19 @@ func download(c *gin.Context) {
 // envs route is for setting environment variables for running docker containers
 func envs(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
+	a := audit(c, "env.set", name)
+	defer a.emit()
 	env := &data.Damafile{}
 	if err := c.Bind(env); err != nil {
 		c.String(500, err.Error())
 		return
 	}
+	// Values can be secrets so only the names are audited
+	var keys []string
+	for _, e := range env.Env {
+		keys = append(keys, strings.SplitN(e, "=", 2)[0])
+	}
+	a.detail("keys", strings.Join(keys, ","))
 	if env.Env == nil {
 		c.String(400, "No environment settings")
 		return
@@ -310,46 +450,32 @@ func envs(c *gin.Context) {
 // create route is called when creating a new on-demand or sandbox container
 func create(c *gin.Context) {
 	name := c.MustGet(gin.AuthUserKey).(string)
+	a := audit(c, "sandbox.create", name)
+	defer a.emit()
 	df := &data.Damafile{}
 	if err := c.Bind(df); err != nil {
 		c.String(500, err.Error())
 		return
 	}
+	a.detail("project", df.Project)
+	a.detail("image", df.Image)
 	if df.Image != "" && !checkImg(df.Image) {
 		c.String(404, df.Image+" Image not found")
 		return
 	}
 	path := pwd + "/upload/" + name
-	err := os.MkdirAll(path, 0755)
+	err := writeScript(path+"/.dama", tmpl, df)
 	if err != nil {
 		c.String(500, err.Error())
 		return
 	}
-	t := template.New("tmpl")
-	t, _ = t.Parse(tmpl)
-	f, _ := os.Create(path + "/.dama")
-	err = os.Chmod(path+"/.dama", 0755)
+	err = setServices(name, df.Services)
 	if err != nil {
-		c.String(500, err.Error())
+		c.String(400, err.Error())
 		return
 	}
-	err = t.Execute(f, df)
-	if err != nil {
-		c.String(500, err.Error())
-		return
-	}
-	err = f.Close()
-	if err != nil {
-		c.String(500, err.Error())
-	}
-
-	if df.Env != nil {
-		var envs = make(map[string]interface{})
-		for _, e := range df.Env {
-			split := strings.Split(e, "=")
-			envs[split[0]] = split[1]
-		}
-		db.HMSet(name+"_env", envs)
-	}
+	db.HMSet(name, map[string]interface{}{"run": genToken(), "sha": df.Git.SHA})
+	setS3(name, df.AWSs3)
+	setEnvs(name, df.Env)
 	c.String(201, "OK")
 }
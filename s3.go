@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// s3Client creates an S3 compatible client with the endpoint, region and credentials from the users env store,
+// which keeps them in plaintext in Redis like every env value
+func s3Client(name string) (*minio.Client, error) {
+	envs, err := db.HGetAll(name + "_env").Result()
+	if err != nil {
+		return nil, err
+	}
+	if envs["AWS_ACCESS_KEY_ID"] == "" || envs["AWS_SECRET_ACCESS_KEY"] == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY need to be set with dama -env")
+	}
+	endpoint := "s3.amazonaws.com"
+	secure := true
+	if e := envs["S3_ENDPOINT"]; e != "" {
+		u, err := url.Parse(e)
+		if err != nil {
+			return nil, err
+		}
+		if u.Host != "" {
+			endpoint = u.Host
+			secure = u.Scheme != "http"
+		} else {
+			endpoint = e
+		}
+	}
+	region := envs["AWS_REGION"]
+	if region == "" {
+		region = envs["AWS_DEFAULT_REGION"]
+	}
+	return minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(envs["AWS_ACCESS_KEY_ID"], envs["AWS_SECRET_ACCESS_KEY"], envs["AWS_SESSION_TOKEN"]),
+		Secure: secure,
+		Region: region,
+	})
+}
+
+// parseBucket splits an s3://bucket/prefix URL into it's bucket and key prefix
+func parseBucket(bucketURL string) (string, string, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.New(bucketURL + " is not a valid s3://bucket/prefix URL")
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// setS3 stores the AWSs3 block of a Damafile so it's used when the next container is created
+func setS3(name string, s3 data.AWSs3) {
+	if s3.BucketPull == "" && s3.BucketPush == "" {
+		db.HDel(name, "s3")
+		return
+	}
+	b, err := json.Marshal(s3)
+	if err != nil {
+		return
+	}
+	db.HSet(name, "s3", b)
+}
+
+// getS3 returns the stored AWSs3 block for a user
+func getS3(name string) (data.AWSs3, error) {
+	var s3 data.AWSs3
+	raw, err := db.HGet(name, "s3").Result()
+	if err != nil || raw == "" {
+		return s3, nil
+	}
+	err = json.Unmarshal([]byte(raw), &s3)
+	return s3, err
+}
+
+// s3PullMarker is written into the workspace while a pull runs, the scripts wait until it's removed and fail
+// with it's content when the pull failed
+const s3PullMarker = ".dama-s3-pull"
+
+// s3Pulls are the users with a pull running
+var s3Pulls = struct {
+	sync.Mutex
+	users map[string]bool
+}{users: make(map[string]bool)}
+
+// mkdirWorkspace creates a directory below root without following symlinks, containers can replace directories
+// in the workspace with symlinks to outside of it
+func mkdirWorkspace(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || !insideDir(root, dir) {
+		return errors.New(dir + " is outside of the workspace")
+	}
+	p := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		p = filepath.Join(p, part)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(p, 0755)
+			if err == nil || os.IsExist(err) {
+				continue
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return errors.New(p + " is not a directory")
+		}
+	}
+	return nil
+}
+
+// s3Get downloads an object next to dst and renames it over dst, so a symlink at dst is replaced and not followed
+func s3Get(ctx context.Context, cl *minio.Client, bucket, key, root, dst string) error {
+	dir := filepath.Dir(dst)
+	err := mkdirWorkspace(root, dir)
+	if err != nil {
+		return err
+	}
+	obj, err := cl.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	tmp, err := ioutil.TempFile(dir, ".s3-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, obj)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// The directory could have been swapped for a symlink after it was checked
+	resolved, err := filepath.EvalSymlinks(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if !insideDir(root, resolved) {
+		return errors.New(key + " resolves to outside of the workspace")
+	}
+	return os.Rename(resolved, filepath.Join(filepath.Dir(resolved), filepath.Base(dst)))
+}
+
+// s3Pull syncs objects from an s3://bucket/prefix URL into the workspace at root and returns how many were downloaded
+func s3Pull(ctx context.Context, cl *minio.Client, root, bucketURL string) (int, error) {
+	bucket, prefix, err := parseBucket(bucketURL)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for obj := range cl.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return n, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(obj.Key, prefix), "/")
+		if rel == "" {
+			rel = path.Base(obj.Key)
+		}
+		dst := filepath.Join(root, filepath.Clean("/"+rel))
+		if dst == filepath.Join(root, s3PullMarker) {
+			continue
+		}
+		if fi, err := os.Lstat(dst); err == nil {
+			if fi.Mode().IsRegular() && fi.Size() == obj.Size && !fi.ModTime().Before(obj.LastModified) {
+				continue
+			}
+			if fi.IsDir() {
+				return n, errors.New(rel + " is a directory in the workspace")
+			}
+		}
+		err = s3Get(ctx, cl, bucket, obj.Key, root, dst)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// s3Put uploads a regular file of the workspace, see openWorkspace
+func s3Put(ctx context.Context, cl *minio.Client, bucket, key, name, file string) error {
+	f, err := openWorkspace(name, file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = cl.PutObject(ctx, bucket, key, f, fi.Size(), minio.PutObjectOptions{})
+	return err
+}
+
+// s3Push uploads File and Dir from the users workspace to BucketPush and returns how many objects were uploaded,
+// symlinks are skipped
+func s3Push(ctx context.Context, cl *minio.Client, name string, s3 data.AWSs3) (int, error) {
+	bucket, prefix, err := parseBucket(s3.BucketPush)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	if s3.File != "" {
+		key := prefix
+		if key == "" || strings.HasSuffix(key, "/") {
+			key += path.Base(filepath.ToSlash(filepath.Clean("/" + s3.File)))
+		}
+		err = s3Put(ctx, cl, bucket, key, name, s3.File)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if s3.Dir != "" {
+		root, err := filepath.EvalSymlinks(pwd + "/upload/" + name)
+		if err != nil {
+			return n, err
+		}
+		dir, err := resolveWorkspace(name, s3.Dir)
+		if err != nil {
+			return n, err
+		}
+		// Walk doesn't follow symlinks below dir
+		err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			file, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			err = s3Put(ctx, cl, bucket, path.Join(prefix, filepath.ToSlash(rel)), name, file)
+			if err != nil {
+				return err
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// logTransfer keeps the last 100 S3 transfers of a user
+func logTransfer(name string, t data.S3Transfer) {
+	b, _ := json.Marshal(t)
+	db.LPush(name+"_s3_transfers", b)
+	db.LTrim(name+"_s3_transfers", 0, 99)
+}
+
+// s3Transfer runs a pull or push of a user, logs it and resets the usage counter
+func s3Transfer(name, direction, bucket string, run func(context.Context, *minio.Client) (int, error)) error {
+	start := time.Now()
+	t := data.S3Transfer{Direction: direction, Bucket: bucket, Status: "ok", Time: start.UTC().Format(time.RFC3339)}
+	defer resetUsage(name)
+	cl, err := s3Client(name)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		t.Objects, err = run(ctx, cl)
+		cancel()
+	}
+	if err != nil {
+		t.Status = "failed"
+		t.Error = err.Error()
+		logger.Error("s3 "+direction+" failed", zap.String("user", name), zap.String("bucket", bucket), zap.Error(err))
+	}
+	t.Duration = time.Since(start).Seconds()
+	logTransfer(name, t)
+	return err
+}
+
+// startS3Pull syncs BucketPull into the users workspace in the background, the marker is written first so the
+// containers scripts wait for it, a pull that's already running isn't started twice
+func startS3Pull(name string, s3 data.AWSs3) error {
+	s3Pulls.Lock()
+	defer s3Pulls.Unlock()
+	if s3Pulls.users[name] {
+		return nil
+	}
+	root, err := filepath.EvalSymlinks(pwd + "/upload/" + name)
+	if err != nil {
+		return err
+	}
+	marker := filepath.Join(root, s3PullMarker)
+	// A symlink at the marker is replaced, not written through
+	os.Remove(marker)
+	err = ioutil.WriteFile(marker, []byte("pulling "+s3.BucketPull+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	s3Pulls.users[name] = true
+	go func() {
+		err := s3Transfer(name, "pull", s3.BucketPull, func(ctx context.Context, cl *minio.Client) (int, error) {
+			return s3Pull(ctx, cl, root, s3.BucketPull)
+		})
+		os.Remove(marker)
+		if err != nil {
+			ioutil.WriteFile(marker, []byte("S3 pull from "+s3.BucketPull+" failed: "+err.Error()+"\n"), 0644)
+		}
+		s3Pulls.Lock()
+		delete(s3Pulls.users, name)
+		s3Pulls.Unlock()
+	}()
+	return nil
+}
+
+// s3PushLabels pushes to S3 in the background with the configuration stored in a removed containers labels
+func s3PushLabels(labels map[string]string) {
+	if labels["s3.bucket_push"] == "" {
+		return
+	}
+	user := labels["user"]
+	s3 := data.AWSs3{BucketPush: labels["s3.bucket_push"], File: labels["s3.file"], Dir: labels["s3.dir"]}
+	go s3Transfer(user, "push", s3.BucketPush, func(ctx context.Context, cl *minio.Client) (int, error) {
+		return s3Push(ctx, cl, user, s3)
+	})
+}
+
+// s3Transfers route returns the last 100 S3 pulls and pushes of the user, newest first
+func s3Transfers(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	raws, err := db.LRange(name+"_s3_transfers", 0, -1).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	ts := []data.S3Transfer{}
+	for _, raw := range raws {
+		var t data.S3Transfer
+		if err := json.Unmarshal([]byte(raw), &t); err == nil {
+			ts = append(ts, t)
+		}
+	}
+	c.JSON(200, ts)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/perlogix/dama/data"
+)
+
+// testMinio connects to the MinIO from S3_TEST_ENDPOINT and creates a bucket, the test is skipped without one:
+//
+//	docker run -d -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=localhost:9000 go test -run S3 .
+func testMinio(t *testing.T) (*minio.Client, string) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT isn't set")
+	}
+	access, secret := os.Getenv("S3_TEST_ACCESS_KEY"), os.Getenv("S3_TEST_SECRET_KEY")
+	if access == "" {
+		access, secret = "minioadmin", "minioadmin"
+	}
+	cl, err := minio.New(endpoint, &minio.Options{Creds: credentials.NewStaticV4(access, secret, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := "dama-test-" + genToken()[:8]
+	if err := cl.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for obj := range cl.ListObjects(context.Background(), bucket, minio.ListObjectsOptions{Recursive: true}) {
+			cl.RemoveObject(context.Background(), bucket, obj.Key, minio.RemoveObjectOptions{})
+		}
+		cl.RemoveBucket(context.Background(), bucket)
+	})
+	return cl, bucket
+}
+
+// writeFiles writes files relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestS3PushPull(t *testing.T) {
+	cl, bucket := testMinio(t)
+	ctx := context.Background()
+	pwd = t.TempDir()
+	outside := t.TempDir()
+	writeFiles(t, outside, map[string]string{"secret": "host file"})
+	writeFiles(t, pwd+"/upload/tim", map[string]string{"model.pkl": "model", "out/a.csv": "a", "out/sub/b.csv": "b"})
+	if err := os.Symlink(filepath.Join(outside, "secret"), pwd+"/upload/tim/out/secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s3Push(ctx, cl, "tim", data.AWSs3{BucketPush: "s3://" + bucket + "/run/", File: "model.pkl", Dir: "out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("pushed %d objects, want 3", n)
+	}
+	if _, err := cl.StatObject(ctx, bucket, "run/secret", minio.StatObjectOptions{}); err == nil {
+		t.Error("a symlink to outside of the workspace was pushed")
+	}
+
+	root := t.TempDir()
+	// A symlinked directory and file in the workspace must not be written through
+	if err := os.Symlink(outside, filepath.Join(root, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "a.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s3Pull(ctx, cl, root, "s3://"+bucket+"/run"); err == nil {
+		t.Error("pulling into a symlinked directory didn't fail")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(outside, "secret")); string(b) != "host file" {
+		t.Errorf("file outside of the workspace was overwritten with %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "b.csv")); err == nil {
+		t.Error("pull wrote into a symlinked directory")
+	}
+
+	os.Remove(filepath.Join(root, "sub"))
+	n, err = s3Pull(ctx, cl, root, "s3://"+bucket+"/run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"model.pkl": "model", "a.csv": "a", "sub/b.csv": "b"} {
+		if b, err := ioutil.ReadFile(filepath.Join(root, file)); err != nil || string(b) != want {
+			t.Errorf("%s = %q, %v, want %q", file, b, err, want)
+		}
+	}
+	if fi, err := os.Lstat(filepath.Join(root, "a.csv")); err != nil || !fi.Mode().IsRegular() {
+		t.Error("the symlink at a.csv wasn't replaced")
+	}
+
+	n, err = s3Pull(ctx, cl, root, "s3://"+bucket+"/run")
+	if err != nil || n != 0 {
+		t.Errorf("second pull downloaded %d objects, %v, want none", n, err)
+	}
+}
+
+func TestMkdirWorkspace(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := mkdirWorkspace(root, filepath.Join(root, "a/b")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "a/b")); err != nil || !fi.IsDir() {
+		t.Errorf("a/b wasn't created: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkdirWorkspace(root, filepath.Join(root, "link/c")); err == nil {
+		t.Error("a directory was created through a symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "c")); err == nil {
+		t.Error("a directory was created outside of the workspace")
+	}
+	if err := mkdirWorkspace(root, filepath.Dir(root)); err == nil {
+		t.Error("a directory outside of the workspace was allowed")
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/leekchan/timeutil"
+	"github.com/perlogix/dama/data"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+)
+
+var (
+	scheduler       = cron.New()
+	scheduleMu      sync.Mutex
+	scheduleEntries = map[string]cron.EntryID{}
+)
+
+// saveSchedule stores a schedule for a user
+func saveSchedule(user string, sched *data.Schedule) error {
+	b, err := json.Marshal(sched)
+	if err != nil {
+		return err
+	}
+	return db.HSet(user+"_schedules", sched.Name, b).Err()
+}
+
+// getSchedule returns a stored schedule for a user
+func getSchedule(user, name string) (*data.Schedule, error) {
+	raw, err := db.HGet(user+"_schedules", name).Result()
+	if err != nil {
+		return nil, errors.New("Schedule " + name + " not found")
+	}
+	sched := &data.Schedule{}
+	err = json.Unmarshal([]byte(raw), sched)
+	if err != nil {
+		return nil, err
+	}
+	return sched, nil
+}
+
+// addSchedule registers a schedule with the cron scheduler, replacing an existing schedule with the same name
+func addSchedule(user string, sched *data.Schedule) error {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	key := user + "/" + sched.Name
+	if id, ok := scheduleEntries[key]; ok {
+		scheduler.Remove(id)
+		delete(scheduleEntries, key)
+	}
+	name := sched.Name
+	id, err := scheduler.AddFunc(sched.Damafile.Schedule, func() { runSchedule(user, name) })
+	if err != nil {
+		return err
+	}
+	scheduleEntries[key] = id
+	return nil
+}
+
+// removeSchedule unregisters a schedule from the cron scheduler
+func removeSchedule(user, name string) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	key := user + "/" + name
+	if id, ok := scheduleEntries[key]; ok {
+		scheduler.Remove(id)
+		delete(scheduleEntries, key)
+	}
+}
+
+// nextRun returns when a registered schedule runs next
+func nextRun(user, name string) string {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	id, ok := scheduleEntries[user+"/"+name]
+	if !ok {
+		return ""
+	}
+	next := scheduler.Entry(id).Next
+	if next.IsZero() {
+		return ""
+	}
+	return next.UTC().Format(time.RFC3339)
+}
+
+// timestampEnv replaces the TIMESTAMP env of a Damafile with the current time
+func timestampEnv(df *data.Damafile) {
+	format := df.TimeFormat
+	if format == "" {
+		format = "%Y%m%d%I%M%S"
+	}
+	var env []string
+	for _, e := range df.Env {
+		if !strings.HasPrefix(e, "TIMESTAMP=") {
+			env = append(env, e)
+		}
+	}
+	t := time.Now()
+	df.Env = append(env, "TIMESTAMP="+timeutil.Strftime(&t, format))
+}
+
+// runSchedule is called by the cron scheduler to run a schedule as a batch job and keep it in the run history
+func runSchedule(user, name string) {
+	sched, err := getSchedule(user, name)
+	if err != nil {
+		removeSchedule(user, name)
+		return
+	}
+	df := sched.Damafile
+	timestampEnv(&df)
+	sched.LastRun = time.Now().UTC().Format(time.RFC3339)
+	job, err := runJob(user, &df, &data.Job{Schedule: name})
+	if err != nil {
+		logger.Error("scheduled job failed to start", zap.String("user", user), zap.String("schedule", name), zap.Error(err))
+		sched.LastJob = ""
+	} else {
+		sched.LastJob = job.ID
+		db.LPush(user+"_schedule_runs_"+name, job.ID)
+		db.LTrim(user+"_schedule_runs_"+name, 0, 99)
+	}
+	saveSchedule(user, sched)
+}
+
+// scheduleDone is called when a job finishes to redeploy a successful scheduled run if the schedule asks for it
+func scheduleDone(user string, job *data.Job) {
+	if job.Schedule == "" || job.Status != jobSucceeded {
+		return
+	}
+	sched, err := getSchedule(user, job.Schedule)
+	if err != nil || !sched.Damafile.Redeploy {
+		return
+	}
+	df := sched.Damafile
+	timestampEnv(&df)
+	_, err = deployDamafile(context.Background(), user, &df)
+	if err != nil {
+		logger.Error("scheduled redeploy failed", zap.String("user", user), zap.String("schedule", sched.Name), zap.Error(err))
+	}
+}
+
+// loadSchedules is ran when starting up to register all schedules kept in the store
+func loadSchedules() {
+	for user := range getAccounts() {
+		all, err := db.HGetAll(user + "_schedules").Result()
+		if err != nil {
+			continue
+		}
+		for _, raw := range all {
+			var sched data.Schedule
+			if err := json.Unmarshal([]byte(raw), &sched); err != nil {
+				continue
+			}
+			if err := addSchedule(user, &sched); err != nil {
+				logger.Error("loading schedule failed", zap.String("user", user), zap.String("schedule", sched.Name), zap.Error(err))
+			}
+		}
+	}
+	scheduler.Start()
+}
+
+// createSchedule route creates or replaces a schedule from a Damafile with a schedule field
+func createSchedule(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	df := &data.Damafile{}
+	if err := c.Bind(df); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if df.Schedule == "" {
+		c.String(400, "No schedule in dama.yml")
+		return
+	}
+	if _, err := cron.ParseStandard(df.Schedule); err != nil {
+		c.String(400, "Invalid schedule: "+err.Error())
+		return
+	}
+	if !validName.MatchString(df.Project) {
+		c.String(400, "Project name can only contain letters, numbers, dots, dashes and underscores")
+		return
+	}
+	if df.Image != "" && !checkImg(df.Image) {
+		c.String(404, df.Image+" Image not found")
+		return
+	}
+	sched := &data.Schedule{Name: df.Project, Damafile: *df, Created: time.Now().UTC().Format(time.RFC3339)}
+	if old, err := getSchedule(name, df.Project); err == nil {
+		sched.Created = old.Created
+		sched.LastRun = old.LastRun
+		sched.LastJob = old.LastJob
+	}
+	err := saveSchedule(name, sched)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	err = addSchedule(name, sched)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	sched.Next = nextRun(name, sched.Name)
+	c.JSON(201, sched)
+}
+
+// listSchedules route lists all schedules for a user
+func listSchedules(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	all, err := db.HGetAll(name + "_schedules").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	scheds := []data.Schedule{}
+	for _, raw := range all {
+		var sched data.Schedule
+		if err := json.Unmarshal([]byte(raw), &sched); err == nil {
+			sched.Next = nextRun(name, sched.Name)
+			scheds = append(scheds, sched)
+		}
+	}
+	sort.Slice(scheds, func(i, j int) bool {
+		return scheds[i].Name < scheds[j].Name
+	})
+	c.JSON(200, scheds)
+}
+
+// showSchedule route returns a schedule with it's run history
+func showSchedule(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	sched, err := getSchedule(name, c.Param("name"))
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	sched.Next = nextRun(name, sched.Name)
+	ids, _ := db.LRange(name+"_schedule_runs_"+sched.Name, 0, -1).Result()
+	for _, id := range ids {
+		if job, err := getJob(name, id); err == nil {
+			sched.Runs = append(sched.Runs, *job)
+		}
+	}
+	c.JSON(200, sched)
+}
+
+// deleteSchedule route removes a schedule and it's run history
+func deleteSchedule(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	sched := c.Param("name")
+	if _, err := getSchedule(name, sched); err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	removeSchedule(name, sched)
+	db.HDel(name+"_schedules", sched)
+	db.Del(name + "_schedule_runs_" + sched)
+	c.String(200, "Deleted")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+)
+
+// service is a web app dama starts in sandboxes, Cmd is formatted with the proxy path and the token
+type service struct {
+	Port int
+	Cmd  string
+}
+
+// sandboxServices are the services dama.yml can declare
+var sandboxServices = map[string]service{
+	"jupyter": {
+		Port: 8888,
+		Cmd: "jupyter lab --ip=0.0.0.0 --port=8888 --no-browser --allow-root --notebook-dir=/root/workspace " +
+			"--ServerApp.base_url=%[1]s --ServerApp.token=%[2]s --ServerApp.allow_remote_access=True",
+	},
+	"tensorboard": {
+		Port: 6006,
+		Cmd:  "tensorboard --logdir=/root/workspace --host=0.0.0.0 --port=6006 --path_prefix=%[1]s",
+	},
+}
+
+// servicePath returns the proxy path of a users service
+func servicePath(user, name string) string {
+	return "/sandbox/" + user + "/" + name
+}
+
+// setServices keeps the services to start in the users next sandbox
+func setServices(name string, services []string) error {
+	for _, s := range services {
+		if _, ok := sandboxServices[s]; !ok {
+			return errors.New("Unknown service " + s + ", services are jupyter and tensorboard")
+		}
+	}
+	if len(services) == 0 {
+		return db.HDel(name, "services").Err()
+	}
+	return db.HSet(name, "services", strings.Join(services, ",")).Err()
+}
+
+// userServices returns the services declared for the users sandbox
+func userServices(name string) []string {
+	services, _ := db.HGet(name, "services").Result()
+	if services == "" {
+		return nil
+	}
+	return strings.Split(services, ",")
+}
+
+// startServices starts the users services in their sandbox in the background with a new token to hand off
+func startServices(name, container string) error {
+	services := userServices(name)
+	if len(services) == 0 {
+		return nil
+	}
+	token := genToken()
+	db.HSet(name, "service_token", token)
+	for _, s := range services {
+		cmd := fmt.Sprintf(sandboxServices[s].Cmd, servicePath(name, s), token)
+		exec, err := client.CreateExec(docker.CreateExecOptions{
+			Container: container,
+			Cmd:       []string{"/bin/sh", "-c", cmd + " > /tmp/" + s + ".log 2>&1"},
+		})
+		if err != nil {
+			return err
+		}
+		err = client.StartExec(exec.ID, docker.StartExecOptions{Detach: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameOrigin reports if a request wasn't sent by a page of another site, browsers send the Origin of the page and
+// Sec-Fetch-Site with cross-origin requests
+func sameOrigin(c *gin.Context) bool {
+	if site := c.GetHeader("Sec-Fetch-Site"); site == "cross-site" || site == "same-site" {
+		return false
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, c.Request.Host)
+}
+
+// sandboxService route proxies to a service in the users sandbox, jupyter gets the token so there's no login prompt.
+// Cross-origin requests are refused, the browser sends the users credentials with them.
+func sandboxService(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	if c.Param("user") != name {
+		c.String(403, "Forbidden")
+		return
+	}
+	if !sameOrigin(c) {
+		c.String(403, "Cross-origin requests are not allowed")
+		return
+	}
+	s := c.Param("service")
+	svc, ok := sandboxServices[s]
+	if !ok || !stringInSlice(s, userServices(name)) {
+		c.String(404, "Service "+s+" not found")
+		return
+	}
+	addr, err := sandboxAddr(name, svc.Port)
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	c.Request.Header.Del("Authorization")
+	if s == "jupyter" {
+		token, _ := db.HGet(name, "service_token").Result()
+		c.Request.Header.Set("Authorization", "token "+token)
+	}
+	proxyTo(c, addr, c.Request.URL.Path)
+}
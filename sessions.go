@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/websocket"
+)
+
+// sessionScrollback is how much recent output is replayed to a client that attaches to a running session
+const sessionScrollback = 64 * 1024
+
+// sessionReconnect is the seconds clients are told to wait before reconnecting
+const sessionReconnect = 10
+
+// session is a shell running with docker exec in a sandbox, it outlives websockets so clients can reconnect to it.
+// The session is recorded from start to end when recording is on.
+type session struct {
+	user       string
+	container  string
+	exec       string
+	stdin      io.WriteCloser
+	mu         sync.Mutex
+	clients    map[*terminal]bool
+	scrollback []byte
+	done       bool
+	shared     bool
+	rec        *recorder
+}
+
+// sessions are the running sessions by user
+var sessions = struct {
+	sync.Mutex
+	m map[string]*session
+}{m: make(map[string]*session)}
+
+// userSession returns the users running session in a sandbox container or starts a new one
+func userSession(name, container string, script bool) (*session, error) {
+	sessions.Lock()
+	defer sessions.Unlock()
+	if sess, ok := sessions.m[name]; ok && sess.container == container && !sess.isDone() {
+		return sess, nil
+	}
+	run, _ := db.HGet(name, "run").Result()
+	env := append(containerEnv(name, run), "TERM=xterm-256color")
+	if script {
+		env = append(env, "DAMA_SCRIPT=true")
+	}
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    container,
+		Cmd:          []string{"/bin/sh", "-c", termShell},
+		Env:          env,
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rec, err := newRecorder(name)
+	if err != nil {
+		return nil, err
+	}
+	stdin, w := io.Pipe()
+	sess := &session{user: name, container: container, exec: exec.ID, stdin: w, clients: make(map[*terminal]bool), rec: rec}
+	cw, err := client.StartExecNonBlocking(exec.ID, docker.StartExecOptions{
+		InputStream:  stdin,
+		OutputStream: sess,
+		ErrorStream:  sess,
+		Tty:          true,
+		RawTerminal:  true,
+	})
+	if err != nil {
+		rec.close()
+		return nil, err
+	}
+	sessions.m[name] = sess
+	go func() {
+		cw.Wait()
+		sess.end()
+		sessions.Lock()
+		if sessions.m[name] == sess {
+			delete(sessions.m, name)
+		}
+		sessions.Unlock()
+	}()
+	return sess, nil
+}
+
+// Write keeps output in the scrollback and queues it for every attached client, clients that fail or can't keep up
+// are dropped and reconnect
+func (s *session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec.output(p)
+	s.scrollback = append(s.scrollback, p...)
+	if len(s.scrollback) > sessionScrollback {
+		s.scrollback = s.scrollback[len(s.scrollback)-sessionScrollback:]
+	}
+	for t := range s.clients {
+		if _, err := t.Write(p); err != nil {
+			delete(s.clients, t)
+			t.close(websocket.CloseTryAgainLater)
+		}
+	}
+	return len(p), nil
+}
+
+// join attaches a client and replays the scrollback, false is returned when the session already ended.
+// Once another user joins the session is shared and everyones input is audited.
+func (s *session) join(t *terminal) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return false
+	}
+	if len(s.scrollback) > 0 {
+		t.Write(s.scrollback)
+	}
+	if t.user != s.user {
+		s.shared = true
+		access := "read-write"
+		if t.readonly {
+			access = "read-only"
+		}
+		s.announce(t.user + " joined " + access)
+	}
+	s.clients[t] = true
+	return true
+}
+
+// leave detaches a client, the shell keeps running for the next one
+func (s *session) leave(t *terminal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[t]; !ok {
+		return
+	}
+	delete(s.clients, t)
+	if t.user != s.user {
+		s.announce(t.user + " left")
+	}
+}
+
+// kick detaches every client of a user from the session
+func (s *session) kick(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for t := range s.clients {
+		if t.user == user {
+			delete(s.clients, t)
+			t.close(websocket.CloseNormalClosure)
+		}
+	}
+}
+
+// announce tells the attached clients about users joining and leaving without keeping it in the scrollback
+func (s *session) announce(msg string) {
+	for t := range s.clients {
+		t.Write([]byte("\r\n[dama] " + msg + "\r\n"))
+	}
+}
+
+// isShared reports if another user joined the session
+func (s *session) isShared() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shared
+}
+
+// isDone reports if the shell of the session exited
+func (s *session) isDone() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+// end closes every client once the shell exits
+func (s *session) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.stdin.Close()
+	s.rec.close()
+	for t := range s.clients {
+		t.close(websocket.CloseNormalClosure)
+	}
+	s.clients = make(map[*terminal]bool)
+}
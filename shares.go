@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// shareAuditSize is how many input events are kept in a users share audit
+const shareAuditSize = 10000
+
+// getShare returns the access a user has to the owners session
+func getShare(owner, user string) (*data.Share, error) {
+	raw, err := db.HGet(owner+"_shares", user).Result()
+	if err != nil {
+		return nil, errors.New("Session not shared with " + user)
+	}
+	share := &data.Share{}
+	err = json.Unmarshal([]byte(raw), share)
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// auditInput records input typed into a shared session in the owners share audit
+func auditInput(owner, user, input string, at time.Time) {
+	b, err := json.Marshal(data.InputEvent{Time: at.UTC().Format(time.RFC3339), User: user, Input: input})
+	if err != nil {
+		return
+	}
+	db.LPush(owner+"_share_audit", b)
+	db.LTrim(owner+"_share_audit", 0, shareAuditSize-1)
+}
+
+// attachShared attaches a user to the running session of the owner with the access the owner granted
+func attachShared(c *gin.Context, name, owner string) {
+	share, err := getShare(owner, name)
+	if err != nil {
+		c.String(403, err.Error())
+		return
+	}
+	sessions.Lock()
+	sess, ok := sessions.m[owner]
+	sessions.Unlock()
+	if !ok || sess.isDone() {
+		c.String(404, "No running session for "+owner)
+		return
+	}
+	serveSession(c, sess, name, share.ReadOnly)
+}
+
+// shareSession route grants another user read-only or read-write access to the users terminal session
+func shareSession(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	share := &data.Share{}
+	if err := c.Bind(share); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if share.User == "" || share.User == name {
+		c.String(400, "Specify another user to share with")
+		return
+	}
+	if exist, _ := db.HGet("accounts", share.User).Result(); exist == "" {
+		c.String(404, "User not found")
+		return
+	}
+	share.Created = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.Marshal(share)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	err = db.HSet(name+"_shares", share.User, b).Err()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	// Access changes apply to a user that's already attached
+	kickShared(name, share.User)
+	c.JSON(201, share)
+}
+
+// listShares route returns the users the session is shared with
+func listShares(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	all, err := db.HGetAll(name + "_shares").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	shares := []data.Share{}
+	for _, raw := range all {
+		var share data.Share
+		if err := json.Unmarshal([]byte(raw), &share); err == nil {
+			shares = append(shares, share)
+		}
+	}
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].User < shares[j].User
+	})
+	c.JSON(200, shares)
+}
+
+// revokeShare route removes a users access and detaches them from the running session
+func revokeShare(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	user := c.Param("user")
+	n, err := db.HDel(name+"_shares", user).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if n == 0 {
+		c.String(404, "Session not shared with "+user)
+		return
+	}
+	kickShared(name, user)
+	c.String(200, "Revoked")
+}
+
+// shareAudit route returns who typed what in the users shared sessions, newest first
+func shareAudit(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	raw, err := db.LRange(name+"_share_audit", 0, -1).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	events := []data.InputEvent{}
+	for _, r := range raw {
+		var e data.InputEvent
+		if err := json.Unmarshal([]byte(r), &e); err == nil {
+			events = append(events, e)
+		}
+	}
+	c.JSON(200, events)
+}
+
+// kickShared detaches a user from the owners running session
+func kickShared(owner, user string) {
+	sessions.Lock()
+	sess, ok := sessions.m[owner]
+	sessions.Unlock()
+	if ok {
+		sess.kick(user)
+	}
+}
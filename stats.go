@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// containerTarget returns the stats target of a container from it's labels and the job ID of batch jobs
+func containerTarget(labels map[string]string) (string, string) {
+	if id, ok := labels["job"]; ok {
+		return "job", id
+	}
+	for target, label := range targets {
+		if _, ok := labels[label]; ok {
+			return target, ""
+		}
+	}
+	return "", ""
+}
+
+// statsFrame converts Docker stats to a sample, CPU is a percent of one core and memory excludes the inactive page cache
+func statsFrame(target, job string, s *docker.Stats) data.ContainerStats {
+	frame := data.ContainerStats{Target: target, Job: job, Time: s.Read.UTC().Format(time.RFC3339), Pids: s.PidsStats.Current}
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	sysDelta := float64(s.CPUStats.SystemCPUUsage) - float64(s.PreCPUStats.SystemCPUUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && sysDelta > 0 {
+		frame.CPUPercent = cpuDelta / sysDelta * cpus * 100
+	}
+	// cgroup v1 reports total_inactive_file and v2 inactive_file
+	inactive := s.MemoryStats.Stats.TotalInactiveFile
+	if inactive == 0 {
+		inactive = s.MemoryStats.Stats.InactiveFile
+	}
+	if s.MemoryStats.Usage > inactive {
+		frame.MemoryUsage = s.MemoryStats.Usage - inactive
+	}
+	frame.MemoryLimit = s.MemoryStats.Limit
+	if DamaConfig.Docker.Memory > 0 {
+		frame.MemoryLimit = uint64(DamaConfig.Docker.Memory)
+	}
+	if frame.MemoryLimit > 0 {
+		frame.MemoryPercent = float64(frame.MemoryUsage) / float64(frame.MemoryLimit) * 100
+	}
+	for _, n := range s.Networks {
+		frame.NetRx += n.RxBytes
+		frame.NetTx += n.TxBytes
+	}
+	for _, b := range s.BlkioStats.IOServiceBytesRecursive {
+		if strings.EqualFold(b.Op, "read") {
+			frame.BlockRead += b.Value
+		} else if strings.EqualFold(b.Op, "write") {
+			frame.BlockWrite += b.Value
+		}
+	}
+	return frame
+}
+
+// streamStats sends samples of a container to frames until the container stops or the request is done
+func streamStats(ctx context.Context, ctr docker.APIContainers, stream bool, frames chan<- data.ContainerStats) {
+	target, job := containerTarget(ctr.Labels)
+	ch := make(chan *docker.Stats)
+	go client.Stats(docker.StatsOptions{ID: ctr.ID, Stats: ch, Stream: stream, Context: ctx})
+	// Stats closes ch when it returns, so it's read to the end
+	for s := range ch {
+		select {
+		case frames <- statsFrame(target, job, s):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// statsStreamTime is how long a stats stream runs, it ends before the servers write timeout and clients reconnect
+const statsStreamTime = writeTimeout - 30*time.Second
+
+// containerStats route streams CPU, memory, network and block IO usage of the users running containers as JSON lines,
+// target limits it to the sandbox or deploy container and stream=false returns one sample of each
+func containerStats(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	filters := []string{"dama", "user=" + name}
+	if target := c.Query("target"); target != "" {
+		label, ok := targets[target]
+		if !ok {
+			c.String(400, "target needs to be deploy or sandbox")
+			return
+		}
+		filters = append(filters, label)
+	}
+	ctrs, err := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": filters}})
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if len(ctrs) == 0 {
+		c.String(404, "No container running")
+		return
+	}
+	stream := c.Query("stream") != "false"
+	ctx, cancel := context.WithTimeout(c.Request.Context(), statsStreamTime)
+	defer cancel()
+	frames := make(chan data.ContainerStats)
+	var wg sync.WaitGroup
+	for _, ctr := range ctrs {
+		wg.Add(1)
+		go func(ctr docker.APIContainers) {
+			defer wg.Done()
+			streamStats(ctx, ctr, stream, frames)
+		}(ctr)
+	}
+	go func() {
+		wg.Wait()
+		close(frames)
+	}()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(200)
+	enc := json.NewEncoder(c.Writer)
+	for frame := range frames {
+		enc.Encode(frame)
+		c.Writer.Flush()
+	}
+}
+
+// watchOOM records OOM kills of dama containers reported by Docker events in the users OOM list
+func watchOOM() {
+	events := make(chan *docker.APIEvents, 16)
+	opts := docker.EventsOptions{Filters: map[string][]string{"type": {"container"}, "event": {"oom"}, "label": {"dama"}}}
+	if err := client.AddEventListenerWithOptions(opts, events); err != nil {
+		logger.Error("watching OOM events failed", zap.Error(err))
+		return
+	}
+	for ev := range events {
+		if ev.Action != "oom" && ev.Status != "oom" {
+			continue
+		}
+		user := ev.Actor.Attributes["user"]
+		if user == "" {
+			continue
+		}
+		target, job := containerTarget(ev.Actor.Attributes)
+		id := ev.Actor.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		oom := data.OOMEvent{Time: time.Unix(0, ev.TimeNano).UTC().Format(time.RFC3339), Target: target, Job: job, Container: id}
+		b, _ := json.Marshal(oom)
+		db.LPush(user+"_oom", b)
+		db.LTrim(user+"_oom", 0, 99)
+		oomKills.WithLabelValues(target).Inc()
+		logger.Warn("container OOM killed", zap.String("user", user), zap.String("target", target), zap.String("container", id))
+	}
+}
+
+// listOOM route returns the users last 100 OOM killed containers, newest first
+func listOOM(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	raws, err := db.LRange(name+"_oom", 0, -1).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	events := []data.OOMEvent{}
+	for _, raw := range raws {
+		var ev data.OOMEvent
+		if err := json.Unmarshal([]byte(raw), &ev); err == nil {
+			events = append(events, ev)
+		}
+	}
+	c.JSON(200, events)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Terminal messages use gotty's protocol so gottyclient can talk to dama or gotty
+const (
+	termInput     = '0'
+	termPing      = '1'
+	termResize    = '2'
+	termOutput    = '0'
+	termPong      = '1'
+	termReconnect = '4'
+)
+
+// termShell runs the dama script when one was requested, otherwise the best shell in the image
+const termShell = `cd /root/workspace
+if [ -n "$DAMA_SCRIPT" ] && [ -f .dama ] && [ -x /bin/bash ]; then exec /bin/bash .dama; fi
+if [ -x /bin/bash ]; then exec /bin/bash; fi
+exec /bin/sh`
+
+// sandboxKeepalive keeps a sandbox container running without gotty until it's removed
+var sandboxKeepalive = []string{"/bin/sh", "-c", "trap 'exit 0' TERM; while :; do sleep 3600 & wait $!; done"}
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 8192}
+
+// termSize is the resize message sent by gottyclient
+type termSize struct {
+	Columns uint `json:"columns"`
+	Rows    uint `json:"rows"`
+}
+
+// termQueue is how many messages can wait for a client before it's too slow and dropped
+const termQueue = 256
+
+// termWriteWait is how long a write to a client can take
+const termWriteWait = 10 * time.Second
+
+// errSlowClient is returned when a clients queue is full
+var errSlowClient = errors.New("client is too slow")
+
+// terminal is a websocket attached to a session, input of a read-only terminal is ignored.
+// Messages are queued and written by writeLoop, so a stalled client can't block the session.
+type terminal struct {
+	conn     *websocket.Conn
+	user     string
+	readonly bool
+	input    []byte
+	inputAt  time.Time
+	out      chan []byte
+	quit     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	code     int
+}
+
+// newTerminal attaches a websocket and starts writing it's queue
+func newTerminal(conn *websocket.Conn, user string, readonly bool) *terminal {
+	t := &terminal{conn: conn, user: user, readonly: readonly, out: make(chan []byte, termQueue),
+		quit: make(chan struct{}), done: make(chan struct{})}
+	go t.writeLoop()
+	return t
+}
+
+// writeLoop writes queued messages until the terminal is closed, the queue is flushed before the close message
+func (t *terminal) writeLoop() {
+	defer close(t.done)
+	for {
+		select {
+		case msg := <-t.out:
+			t.conn.SetWriteDeadline(time.Now().Add(termWriteWait))
+			if err := t.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				t.close(websocket.CloseAbnormalClosure)
+				t.conn.Close()
+				return
+			}
+		case <-t.quit:
+			t.conn.SetWriteDeadline(time.Now().Add(termWriteWait))
+			for len(t.out) > 0 {
+				if err := t.conn.WriteMessage(websocket.TextMessage, <-t.out); err != nil {
+					break
+				}
+			}
+			t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(t.code, ""), time.Now().Add(time.Second))
+			t.conn.Close()
+			return
+		}
+	}
+}
+
+// send queues a single protocol message for the websocket
+func (t *terminal) send(msg byte, p []byte) error {
+	select {
+	case <-t.quit:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case t.out <- append([]byte{msg}, p...):
+		return nil
+	default:
+		return errSlowClient
+	}
+}
+
+// Write sends TTY output to the client
+func (t *terminal) Write(p []byte) (int, error) {
+	err := t.send(termOutput, []byte(base64.StdEncoding.EncodeToString(p)))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close tells the client the terminal is closed with a close code once the queue is written, a normal closure means
+// the shell exited
+func (t *terminal) close(code int) {
+	t.once.Do(func() {
+		t.code = code
+		close(t.quit)
+	})
+}
+
+// readLoop passes input and resizes from the client to the session until the websocket is closed
+func (t *terminal) readLoop(sess *session) {
+	for {
+		_, msg, err := t.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case termInput:
+			if t.readonly {
+				continue
+			}
+			if sess.isShared() {
+				t.typed(sess.user, msg[1:])
+			}
+			sess.rec.input(msg[1:])
+			if _, err := sess.stdin.Write(msg[1:]); err != nil {
+				return
+			}
+		case termPing:
+			t.send(termPong, nil)
+		case termResize:
+			if t.readonly {
+				continue
+			}
+			size := termSize{}
+			if err := json.Unmarshal(msg[1:], &size); err != nil || size.Columns == 0 || size.Rows == 0 {
+				continue
+			}
+			client.ResizeExecTTY(sess.exec, int(size.Rows), int(size.Columns))
+			sess.rec.resize(size.Columns, size.Rows)
+		}
+	}
+}
+
+// typed buffers input for the share audit until a line is entered
+func (t *terminal) typed(owner string, p []byte) {
+	if len(t.input) == 0 {
+		t.inputAt = time.Now()
+	}
+	t.input = append(t.input, p...)
+	if bytes.ContainsAny(p, "\r\n") || len(t.input) >= 256 {
+		t.flushInput(owner)
+	}
+}
+
+// flushInput records the buffered input in the owners share audit
+func (t *terminal) flushInput(owner string) {
+	if len(t.input) > 0 {
+		auditInput(owner, t.user, string(t.input), t.inputAt)
+		t.input = nil
+	}
+}
+
+// sandboxContainer returns the users running sandbox, a new one is created when there's none or new is set
+func sandboxContainer(c *gin.Context, name, image, file, port string, new bool) (*docker.APIContainers, error) {
+	if new {
+		deleteContainers(name, "build")
+	} else if ctr, err := userContainer(name, "build"); err == nil {
+		if ctr.State == "running" {
+			return ctr, nil
+		}
+		removeContainer(*ctr)
+	}
+	release, err := admit(c.Request.Context(), name, kindSandbox)
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := createContainer(name, image, file, port, false)
+	release()
+	if err != nil {
+		return nil, err
+	}
+	sbAPI, _ := db.HGet(name, "sandbox").Result()
+	db.HSet("sandboxPort", sbAPI, strings.Split(ctr, ":")[1])
+	return userContainer(name, "build")
+}
+
+// attach serves a terminal over a websocket with the users session in their sandbox, so images don't need gotty
+func attach(c *gin.Context, name, image, file, port string, new bool) {
+	ctr, err := sandboxContainer(c, name, image, file, port, new)
+	if err != nil {
+		c.String(503, err.Error())
+		return
+	}
+	sess, err := userSession(name, ctr.ID, file != "")
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	serveSession(c, sess, name, false)
+}
+
+// serveSession attaches a users websocket to a session until either is closed
+func serveSession(c *gin.Context, sess *session, name string, readonly bool) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// gottyclient starts with it's arguments which dama doesn't use
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return
+	}
+
+	t := newTerminal(conn, name, readonly)
+	// The writer is stopped when the client went away and the queue is written before the websocket is closed,
+	// an earlier close code is kept
+	defer func() {
+		t.close(websocket.CloseGoingAway)
+		<-t.done
+	}()
+	t.send(termReconnect, []byte(strconv.Itoa(sessionReconnect)))
+	if !sess.join(t) {
+		t.close(websocket.CloseNormalClosure)
+		return
+	}
+	defer sess.leave(t)
+	t.readLoop(sess)
+	t.flushInput(sess.user)
+}
@@ -1,9 +1,11 @@
 package main
 
-// tmpl is a text/template to create a bash script in /root/workspace/.dama
-var tmpl = `#!/bin/bash
-if [[ ! -f /root/.dama ]]; then
-touch /root/.dama
+// tmplRun is the setup and run steps shared by the sandbox and batch job scripts
+var tmplRun = `
+{{if .AWSs3.BucketPull}}
+while grep -qs '^pulling' /root/workspace/.dama-s3-pull; do sleep 1; done
+if [[ -f /root/workspace/.dama-s3-pull ]]; then cat /root/workspace/.dama-s3-pull; exit 1; fi
+{{- end}}
 {{if .SetupCmd}}
 {{ .SetupCmd }}
 {{- end}}
@@ -20,20 +22,21 @@ git clone {{.Git.URL}} -b {{.Git.Branch}}
 git clone {{.Git.URL}}
 {{- end}}
 {{- end}}
-{{if .AWSs3.BucketPull}}
-aws s3 sync {{.AWSs3.BucketPull}} .
-{{- end}}
 {{ .Cmd }}
 {{if .Python}}
 python << EOF
 {{ .Python }}
 EOF
 {{- end}}
-{{if and .AWSs3.File .AWSs3.BucketPush}}
-aws s3 cp {{.AWSs3.File}} {{.AWSs3.BucketPush}}
-{{- end}}
-{{if and .AWSs3.Dir .AWSs3.BucketPush}}
-aws s3 sync {{.AWSs3.Dir}} {{.AWSs3.BucketPush}}
-{{- end}}
-fi
+`
+
+// tmpl is a text/template to create a bash script in /root/workspace/.dama
+var tmpl = `#!/bin/bash
+if [[ ! -f /root/.dama ]]; then
+touch /root/.dama` + tmplRun + `fi
 bash`
+
+// jobTmpl is a text/template to create a non-interactive bash script for batch jobs, the first failing step exits the job
+var jobTmpl = `#!/bin/bash
+set -e
+cd /root/workspace` + tmplRun
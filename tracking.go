@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+	"go.uber.org/zap"
+)
+
+// trackedRun is stored for a tracking token so containers can post without the users key
+type trackedRun struct {
+	User      string `json:"user"`
+	Project   string `json:"project"`
+	Timestamp string `json:"timestamp"`
+	RunID     string `json:"run_id"`
+}
+
+// runKey returns the prefix used for the params and metrics keys of a run, projects and timestamps are valid names
+// so they can't contain the colons
+func runKey(user, project, ts string) string {
+	return user + ":run:" + project + ":" + ts
+}
+
+// runsKey returns the hash of the runs of a project
+func runsKey(user, project string) string {
+	return user + ":runs:" + project
+}
+
+// metricsFile returns the workspace file a run writes it's metrics to
+func metricsFile(project, ts string) string {
+	return "metrics/" + project + "/" + ts + ".json"
+}
+
+// envValue returns the value of a key in a key=value env slice
+func envValue(env []string, key string) string {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return strings.TrimPrefix(e, key+"=")
+		}
+	}
+	return ""
+}
+
+// trackingEnv registers a tracking token for the PROJECT and TIMESTAMP of a container and adds DAMA_TRACKING_URL
+// and DAMA_METRICS_FILE
+func trackingEnv(name string, env []string, labels map[string]string) []string {
+	project := envValue(env, "PROJECT")
+	ts := envValue(env, "TIMESTAMP")
+	if project == "" || ts == "" {
+		return env
+	}
+	if !validName.MatchString(project) || !validName.MatchString(ts) {
+		logger.Warn("run isn't tracked, PROJECT and TIMESTAMP need to be letters, numbers, dots, dashes or underscores",
+			zap.String("user", name), zap.String("project", project), zap.String("timestamp", ts))
+		return env
+	}
+	root, err := filepath.EvalSymlinks(pwd + "/upload/" + name)
+	if err == nil {
+		err = mkdirWorkspace(root, filepath.Dir(filepath.Join(root, metricsFile(project, ts))))
+	}
+	if err != nil {
+		logger.Warn("creating the metrics directory failed", zap.String("user", name), zap.Error(err))
+	}
+	token := genToken()
+	b, err := json.Marshal(trackedRun{User: name, Project: project, Timestamp: ts, RunID: envValue(env, "RUN_ID")})
+	if err != nil {
+		return env
+	}
+	db.HSet("tracking", token, b)
+	labels["tracking"] = token
+	labels["project"] = project
+	labels["timestamp"] = ts
+	return append(env, "DAMA_TRACKING_URL="+strings.TrimSuffix(DamaConfig.Tracking.URL, "/")+"/track/"+token,
+		"DAMA_METRICS_FILE=/root/workspace/"+metricsFile(project, ts))
+}
+
+// recordTracking stores params and metrics for a run, replace overwrites metric series instead of appending
+func recordTracking(run trackedRun, t data.Tracking, replace bool) error {
+	key := runKey(run.User, run.Project, run.Timestamp)
+	meta, err := json.Marshal(data.Run{RunID: run.RunID, Created: time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+	db.SAdd(run.User+"_experiments", run.Project)
+	db.HSetNX(runsKey(run.User, run.Project), run.Timestamp, meta)
+	for k, v := range t.Params {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		db.HSet(key+":params", k, b)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for name, v := range t.Metrics {
+		var values []float64
+		switch m := v.(type) {
+		case float64:
+			values = []float64{m}
+		case []interface{}:
+			for _, i := range m {
+				if f, ok := i.(float64); ok {
+					values = append(values, f)
+				}
+			}
+		default:
+			continue
+		}
+		list := key + ":metric:" + name
+		var step int64
+		if replace {
+			db.Del(list)
+		} else {
+			step, _ = db.LLen(list).Result()
+		}
+		if t.Step != nil && len(values) == 1 {
+			step = int64(*t.Step)
+		}
+		for i, f := range values {
+			b, err := json.Marshal(data.MetricPoint{Step: int(step) + i, Value: f, Time: now})
+			if err != nil {
+				return err
+			}
+			db.RPush(list, b)
+		}
+		db.SAdd(key+":metrics", name)
+	}
+	return nil
+}
+
+// ingestMetricsFile records the metrics file of a run in the users workspace, a file without
+// params or metrics keys is read as top-level metrics
+func ingestMetricsFile(run trackedRun) error {
+	b, err := readWorkspace(run.User, metricsFile(run.Project, run.Timestamp))
+	if err != nil {
+		return err
+	}
+	var t data.Tracking
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return err
+	}
+	if t.Params == nil && t.Metrics == nil {
+		err = json.Unmarshal(b, &t.Metrics)
+		if err != nil {
+			return err
+		}
+	}
+	return recordTracking(run, t, true)
+}
+
+// trackingDone records the final metrics file of the containers run and removes the tracking token of a removed container
+func trackingDone(labels map[string]string) {
+	if labels["tracking"] == "" {
+		return
+	}
+	run := trackedRun{User: labels["user"], Project: labels["project"], Timestamp: labels["timestamp"]}
+	if raw, err := db.HGet("tracking", labels["tracking"]).Result(); err == nil {
+		json.Unmarshal([]byte(raw), &run)
+	}
+	if err := ingestMetricsFile(run); err != nil && !os.IsNotExist(err) {
+		logger.Warn("reading metrics file failed", zap.String("user", run.User), zap.Error(err))
+	}
+	db.HDel("tracking", labels["tracking"])
+}
+
+// watchMetrics is ran in background via goroutine to record the metrics file of running containers when it changes
+func watchMetrics() {
+	seen := make(map[string]time.Time)
+	for {
+		ctrs, _ := client.ListContainers(docker.ListContainersOptions{Filters: map[string][]string{"label": {"tracking"}}})
+		running := make(map[string]time.Time)
+		for _, ctr := range ctrs {
+			user := ctr.Labels["user"]
+			project, ts := ctr.Labels["project"], ctr.Labels["timestamp"]
+			fi, err := os.Stat(workspaceFile(user, metricsFile(project, ts)))
+			if err != nil {
+				continue
+			}
+			running[ctr.ID] = seen[ctr.ID]
+			if !fi.ModTime().After(seen[ctr.ID]) {
+				continue
+			}
+			run := trackedRun{User: user, Project: project, Timestamp: ts}
+			if raw, err := db.HGet("tracking", ctr.Labels["tracking"]).Result(); err == nil {
+				json.Unmarshal([]byte(raw), &run)
+			}
+			if err := ingestMetricsFile(run); err != nil {
+				logger.Warn("reading metrics file failed", zap.String("user", user), zap.Error(err))
+			}
+			running[ctr.ID] = fi.ModTime()
+		}
+		seen = running
+		time.Sleep(time.Second * 10)
+	}
+}
+
+// loadRun returns the params and last metric values of a run, series adds every metric point
+func loadRun(user, project, ts string, series bool) (*data.Run, error) {
+	if !validName.MatchString(project) || !validName.MatchString(ts) {
+		return nil, errors.New("Run " + project + " " + ts + " not found")
+	}
+	raw, err := db.HGet(runsKey(user, project), ts).Result()
+	if err != nil {
+		return nil, errors.New("Run " + project + " " + ts + " not found")
+	}
+	run := &data.Run{}
+	err = json.Unmarshal([]byte(raw), run)
+	if err != nil {
+		return nil, err
+	}
+	run.Project = project
+	run.Timestamp = ts
+	run.Params = make(map[string]interface{})
+	run.Metrics = make(map[string]float64)
+	key := runKey(user, project, ts)
+	params, _ := db.HGetAll(key + ":params").Result()
+	for k, v := range params {
+		var p interface{}
+		if json.Unmarshal([]byte(v), &p) == nil {
+			run.Params[k] = p
+		}
+	}
+	names, _ := db.SMembers(key + ":metrics").Result()
+	if series {
+		run.Series = make(map[string][]data.MetricPoint)
+	}
+	for _, name := range names {
+		var points []string
+		if series {
+			points, _ = db.LRange(key+":metric:"+name, 0, -1).Result()
+		} else {
+			points, _ = db.LRange(key+":metric:"+name, -1, -1).Result()
+		}
+		for _, p := range points {
+			var point data.MetricPoint
+			if json.Unmarshal([]byte(p), &point) != nil {
+				continue
+			}
+			run.Metrics[name] = point.Value
+			if series {
+				run.Series[name] = append(run.Series[name], point)
+			}
+		}
+	}
+	return run, nil
+}
+
+// track route is called from containers with DAMA_TRACKING_URL to post params and metrics
+func track(c *gin.Context) {
+	raw, err := db.HGet("tracking", c.Param("token")).Result()
+	if err != nil {
+		c.String(404, "Tracking token not found")
+		return
+	}
+	var run trackedRun
+	if err := json.Unmarshal([]byte(raw), &run); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	var t data.Tracking
+	if err := c.BindJSON(&t); err != nil {
+		c.String(400, err.Error())
+		return
+	}
+	if err := recordTracking(run, t, false); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	c.String(201, "Tracked")
+}
+
+// listExperiments route lists the projects with tracked runs
+func listExperiments(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	projects, err := db.SMembers(name + "_experiments").Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	sort.Strings(projects)
+	exps := []data.Experiment{}
+	for _, p := range projects {
+		runs, _ := db.HLen(runsKey(name, p)).Result()
+		exps = append(exps, data.Experiment{Project: p, Runs: runs})
+	}
+	c.JSON(200, exps)
+}
+
+// showExperiment route returns the runs of a project with params and last metric values to compare,
+// the runs query param is a comma separated list of timestamps to compare
+func showExperiment(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	project := c.Param("project")
+	var timestamps []string
+	if r := c.Query("runs"); r != "" {
+		timestamps = strings.Split(r, ",")
+	} else {
+		timestamps, _ = db.HKeys(runsKey(name, project)).Result()
+	}
+	sort.Strings(timestamps)
+	runs := []data.Run{}
+	for _, ts := range timestamps {
+		run, err := loadRun(name, project, ts, false)
+		if err != nil {
+			c.String(404, err.Error())
+			return
+		}
+		runs = append(runs, *run)
+	}
+	c.JSON(200, runs)
+}
+
+// showRun route returns a single run with every metric point
+func showRun(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	run, err := loadRun(name, c.Param("project"), c.Param("run"), true)
+	if err != nil {
+		c.String(404, err.Error())
+		return
+	}
+	c.JSON(200, run)
+}
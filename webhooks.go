@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gin-gonic/gin"
+	"github.com/perlogix/dama/data"
+)
+
+// Events sent to webhooks, ping is only sent when a webhook is tested
+const (
+	eventPing            = "ping"
+	eventDeployHealthy   = "deploy.healthy"
+	eventDeployFailed    = "deploy.failed"
+	eventJobFinished     = "job.finished"
+	eventSandboxExpiring = "sandbox.expiring"
+)
+
+// webhookEvents are the events a webhook can subscribe to
+var webhookEvents = []string{eventDeployHealthy, eventDeployFailed, eventJobFinished, eventSandboxExpiring}
+
+// webhookClient posts notifications to the global webhooks, a webhook that doesn't answer in time is retried
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// userWebhookClient posts notifications to the webhooks of users, it only connects to public addresses so webhooks
+// can't reach the server or it's network
+var userWebhookClient = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+	DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}).DialContext,
+	TLSHandshakeTimeout: 5 * time.Second,
+}}
+
+// privateNets are the networks that aren't reachable from the internet besides loopback, link-local and multicast
+var privateNets = parseNets("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16",
+	"198.18.0.0/15", "240.0.0.0/4", "fc00::/7")
+
+// parseNets parses CIDRs
+func parseNets(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// publicIP reports if an IP is reachable from the internet
+func publicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicOnly is a dialer control that refuses connections to addresses that aren't public, it's checked after
+// the host is resolved so DNS can't point a webhook to the server
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicIP(net.ParseIP(host)) {
+		return errors.New("webhook address " + host + " is not public")
+	}
+	return nil
+}
+
+// webhookBackoff is the wait after the first failed attempt of a delivery, it doubles with every attempt up to a minute
+var webhookBackoff = time.Second
+
+// signPayload returns the X-Dama-Signature of a payload, the hex HMAC-SHA256 of the body with the webhooks secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook posts a signed notification body to a webhook and returns the response status
+func postWebhook(cl *http.Client, hook data.Webhook, n data.Notification, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "dama-webhook")
+	req.Header.Set("X-Dama-Event", n.Event)
+	req.Header.Set("X-Dama-Delivery", n.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-Dama-Signature", signPayload(hook.Secret, body))
+	}
+	resp, err := cl.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// sendWebhook delivers a notification to a webhook with a client in up to retries attempts, network errors, 429 and
+// 5xx responses are retried with backoff and other responses above 299 fail right away
+func sendWebhook(cl *http.Client, hook data.Webhook, n data.Notification, retries int) data.Delivery {
+	start := time.Now()
+	d := data.Delivery{ID: n.ID, Webhook: hook.Name, Event: n.Event, URL: hook.URL, Time: start.UTC().Format(time.RFC3339)}
+	body, err := json.Marshal(n)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	if retries < 1 {
+		retries = 1
+	}
+	wait := webhookBackoff
+	for d.Attempts < retries {
+		d.Attempts++
+		d.Status, err = postWebhook(cl, hook, n, body)
+		if err == nil && d.Status < 300 {
+			d.Error = ""
+			break
+		}
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Error = "webhook responded " + strconv.Itoa(d.Status)
+			if d.Status < 500 && d.Status != 429 {
+				break
+			}
+		}
+		if d.Attempts < retries {
+			time.Sleep(wait)
+			if wait *= 2; wait > time.Minute {
+				wait = time.Minute
+			}
+		}
+	}
+	d.Duration = time.Since(start).Seconds()
+	return d
+}
+
+// wantsEvent reports if a webhook subscribed to an event, every webhook gets pings
+func wantsEvent(events []string, event string) bool {
+	return len(events) == 0 || event == eventPing || stringInSlice(event, events)
+}
+
+// userWebhooks returns the webhooks of the user with their secrets
+func userWebhooks(name string) []data.Webhook {
+	all, _ := db.HGetAll(name + "_webhooks").Result()
+	hooks := []data.Webhook{}
+	for _, raw := range all {
+		var hook data.Webhook
+		if err := json.Unmarshal([]byte(raw), &hook); err == nil {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Name < hooks[j].Name
+	})
+	return hooks
+}
+
+// logDelivery keeps the last 100 deliveries in a list, users have their own and global webhooks share one
+func logDelivery(key string, d data.Delivery) {
+	b, _ := json.Marshal(d)
+	db.LPush(key, b)
+	db.LTrim(key, 0, 99)
+}
+
+// notify sends an event of a user to their webhooks and the global ones in the background
+func notify(user, event, text string, payload interface{}) {
+	n := data.Notification{ID: genToken(), Event: event, User: user, Time: time.Now().UTC().Format(time.RFC3339), Text: text, Data: payload}
+	for _, hook := range userWebhooks(user) {
+		if wantsEvent(hook.Events, event) {
+			go func(hook data.Webhook) {
+				logDelivery(user+"_webhook_deliveries", sendWebhook(userWebhookClient, hook, n, DamaConfig.Notify.Retries))
+			}(hook)
+		}
+	}
+	for _, g := range DamaConfig.Notify.Webhooks {
+		if wantsEvent(g.Events, event) {
+			hook := data.Webhook{Name: g.Name, URL: g.URL, Secret: g.Secret, Events: g.Events}
+			go func() {
+				logDelivery("webhook_deliveries", sendWebhook(webhookClient, hook, n, DamaConfig.Notify.Retries))
+			}()
+		}
+	}
+}
+
+// watchDeploy notifies when the users deployed API answers below 500 or it's container stops before that,
+// the watch ends quietly when a newer deploy replaced the container
+func watchDeploy(name, key string) {
+	first, err := userContainer(name, "API")
+	if err != nil {
+		notify(name, eventDeployFailed, "Deploy of "+name+" failed: "+err.Error(), map[string]string{"api": key, "error": err.Error()})
+		return
+	}
+	sha, _ := db.HGet(name, "sha").Result()
+	payload := map[string]string{"api": key, "sha": sha, "container": first.ID}
+	cl := &http.Client{Timeout: 5 * time.Second}
+	deadline := time.Now().Add(time.Duration(DamaConfig.Notify.HealthTimeout) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		ctr, err := client.InspectContainer(first.ID)
+		if _, removed := err.(*docker.NoSuchContainer); removed {
+			if latest, err := userContainer(name, "API"); err == nil && latest.ID != first.ID {
+				return
+			}
+		}
+		if err != nil || !ctr.State.Running {
+			payload["error"] = "container stopped"
+			notify(name, eventDeployFailed, "Deploy of "+name+" stopped before it was healthy", payload)
+			return
+		}
+		if backend := apiBackend(key); backend != "" {
+			resp, err := cl.Get("http://" + backend + "/")
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 500 {
+					notify(name, eventDeployHealthy, "Deploy of "+name+" is healthy", payload)
+					return
+				}
+			}
+		}
+	}
+	payload["error"] = fmt.Sprintf("not healthy after %ds", DamaConfig.Notify.HealthTimeout)
+	notify(name, eventDeployFailed, "Deploy of "+name+" is "+payload["error"], payload)
+}
+
+// warnExpiry notifies once that a users sandbox expires in remaining seconds
+func warnExpiry(ctr docker.APIContainers, remaining int) {
+	warned, err := db.SetNX("expiry_warned_"+ctr.ID, 1, time.Hour).Result()
+	if err != nil || !warned {
+		return
+	}
+	user := ctr.Labels["user"]
+	in := (time.Duration(remaining) * time.Second).String()
+	notify(user, eventSandboxExpiring, "Sandbox of "+user+" expires in "+in, map[string]interface{}{"expires_in": remaining, "container": ctr.ID})
+}
+
+// createWebhook route adds a webhook for the users events, a secret is generated when there's none and only returned once
+func createWebhook(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	hook := &data.Webhook{}
+	if err := c.Bind(hook); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !validName.MatchString(hook.Name) {
+		c.String(400, "Webhook name needs to be letters, numbers, dots, dashes or underscores")
+		return
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.String(400, "Webhook URL needs to be an http or https URL")
+		return
+	}
+	// Checked again when it's posted, DNS can change
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		c.String(400, "Webhook host can't be resolved: "+err.Error())
+		return
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			c.String(400, "Webhook URL needs to be a public address")
+			return
+		}
+	}
+	for _, e := range hook.Events {
+		if !stringInSlice(e, webhookEvents) {
+			c.String(400, "Unknown event "+e+", events are "+fmt.Sprint(webhookEvents))
+			return
+		}
+	}
+	if hook.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			c.String(500, err.Error())
+			return
+		}
+		hook.Secret = hex.EncodeToString(b)
+	}
+	hook.Created = time.Now().UTC().Format(time.RFC3339)
+	raw, err := json.Marshal(hook)
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	added, err := db.HSetNX(name+"_webhooks", hook.Name, raw).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if !added {
+		c.String(409, "Webhook "+hook.Name+" already exists")
+		return
+	}
+	c.JSON(201, hook)
+}
+
+// listWebhooks route returns the users webhooks without their secrets
+func listWebhooks(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	hooks := userWebhooks(name)
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	c.JSON(200, hooks)
+}
+
+// deleteWebhook route removes a webhook of the user
+func deleteWebhook(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	n, err := db.HDel(name+"_webhooks", c.Param("name")).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	if n == 0 {
+		c.String(404, "Webhook not found")
+		return
+	}
+	c.String(200, "Deleted")
+}
+
+// testWebhook route sends a ping to a webhook of the user once and returns the delivery
+func testWebhook(c *gin.Context) {
+	name := c.MustGet(gin.AuthUserKey).(string)
+	raw, err := db.HGet(name+"_webhooks", c.Param("name")).Result()
+	if err != nil {
+		c.String(404, "Webhook not found")
+		return
+	}
+	var hook data.Webhook
+	if err := json.Unmarshal([]byte(raw), &hook); err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	n := data.Notification{ID: genToken(), Event: eventPing, User: name, Time: time.Now().UTC().Format(time.RFC3339), Text: "Ping from dama"}
+	d := sendWebhook(userWebhookClient, hook, n, 1)
+	logDelivery(name+"_webhook_deliveries", d)
+	c.JSON(200, d)
+}
+
+// deliveries returns the deliveries logged in a list, newest first
+func deliveries(c *gin.Context, key string) {
+	raws, err := db.LRange(key, 0, -1).Result()
+	if err != nil {
+		c.String(500, err.Error())
+		return
+	}
+	ds := []data.Delivery{}
+	for _, raw := range raws {
+		var d data.Delivery
+		if err := json.Unmarshal([]byte(raw), &d); err == nil {
+			ds = append(ds, d)
+		}
+	}
+	c.JSON(200, ds)
+}
+
+// webhookDeliveries route returns the last 100 deliveries to the users webhooks
+func webhookDeliveries(c *gin.Context) {
+	deliveries(c, c.MustGet(gin.AuthUserKey).(string)+"_webhook_deliveries")
+}
+
+// adminDeliveries route returns the last 100 deliveries to the global webhooks
+func adminDeliveries(c *gin.Context) {
+	deliveries(c, "webhook_deliveries")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/perlogix/dama/data"
+)
+
+// receiver is a local webhook receiver answering with the statuses in order, the last one repeats
+func receiver(t *testing.T, statuses ...int) (*httptest.Server, *[]*http.Request, *[][]byte) {
+	var reqs []*http.Request
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		reqs = append(reqs, r)
+		bodies = append(bodies, body)
+		status := statuses[len(statuses)-1]
+		if len(reqs) <= len(statuses) {
+			status = statuses[len(reqs)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs, &bodies
+}
+
+func TestSendWebhookSigned(t *testing.T) {
+	srv, reqs, bodies := receiver(t, 204)
+	hook := data.Webhook{Name: "local", URL: srv.URL, Secret: "s3cret"}
+	n := data.Notification{ID: "abc123", Event: eventJobFinished, User: "tim", Text: "Job finished"}
+	d := sendWebhook(webhookClient, hook, n, 3)
+	if d.Status != 204 || d.Attempts != 1 || d.Error != "" {
+		t.Fatalf("delivery = %+v, want status 204 in 1 attempt", d)
+	}
+	r, body := (*reqs)[0], (*bodies)[0]
+	if got := r.Header.Get("X-Dama-Signature"); got != signPayload("s3cret", body) {
+		t.Errorf("signature = %q, want %q", got, signPayload("s3cret", body))
+	}
+	if r.Header.Get("X-Dama-Event") != eventJobFinished || r.Header.Get("X-Dama-Delivery") != "abc123" {
+		t.Errorf("headers = %v", r.Header)
+	}
+	var got data.Notification
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != n.Event || got.User != n.User || got.Text != n.Text {
+		t.Errorf("payload = %+v, want %+v", got, n)
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	srv, reqs, _ := receiver(t, 500, 429, 200)
+	d := sendWebhook(webhookClient, data.Webhook{URL: srv.URL}, data.Notification{Event: eventPing}, 5)
+	if d.Status != 200 || d.Attempts != 3 || d.Error != "" {
+		t.Fatalf("delivery = %+v, want status 200 in 3 attempts", d)
+	}
+	if len(*reqs) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(*reqs))
+	}
+	if (*reqs)[0].Header.Get("X-Dama-Signature") != "" {
+		t.Error("a webhook without a secret got a signature")
+	}
+}
+
+func TestSendWebhookGivesUp(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	srv, reqs, _ := receiver(t, 503)
+	d := sendWebhook(webhookClient, data.Webhook{URL: srv.URL}, data.Notification{Event: eventPing}, 3)
+	if d.Status != 503 || d.Attempts != 3 || d.Error == "" {
+		t.Fatalf("delivery = %+v, want a failed delivery after 3 attempts", d)
+	}
+
+	srv, reqs, _ = receiver(t, 404)
+	d = sendWebhook(webhookClient, data.Webhook{URL: srv.URL}, data.Notification{Event: eventPing}, 3)
+	if d.Status != 404 || d.Attempts != 1 || len(*reqs) != 1 {
+		t.Fatalf("delivery = %+v, a 404 isn't retried", d)
+	}
+}
+
+func TestWantsEvent(t *testing.T) {
+	if !wantsEvent(nil, eventDeployFailed) {
+		t.Error("a webhook without events gets every event")
+	}
+	if wantsEvent([]string{eventJobFinished}, eventDeployFailed) {
+		t.Error("a webhook got an event it didn't subscribe to")
+	}
+	if !wantsEvent([]string{eventJobFinished}, eventPing) {
+		t.Error("every webhook gets pings")
+	}
+}
+
+func TestUserWebhookPublicOnly(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	srv, reqs, _ := receiver(t, 200)
+	d := sendWebhook(userWebhookClient, data.Webhook{URL: srv.URL}, data.Notification{Event: eventPing}, 2)
+	if d.Error == "" || len(*reqs) != 0 {
+		t.Fatalf("delivery = %+v, a user webhook reached the loopback address", d)
+	}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.17.0.1", "192.168.1.1", "169.254.169.254", "::1", "fd00::1", "0.0.0.0"} {
+		if publicIP(net.ParseIP(ip)) {
+			t.Errorf("%s is public", ip)
+		}
+	}
+	if !publicIP(net.ParseIP("93.184.216.34")) {
+		t.Error("93.184.216.34 isn't public")
+	}
+}